@@ -17,9 +17,12 @@ limitations under the License.
 package v1alpha1
 
 import (
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -36,12 +39,32 @@ type ArgoWorkFlowSpec struct {
 	// +kubebuilder:default:=1
 	Replicas int32 `json:"replicas,omitempty"`
 
-	// +kubebuilder:validation:Required
-	Resources *corev1.ResourceRequirements `json:"resources"`
+	// Resources of the controller container. Takes precedence over
+	// ResourceProfile.
+	// +kubebuilder:validation:Optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// ResourceProfile selects preset controller resources when Resources is
+	// not set. Requests and limits of CPU/memory are 100m/128Mi and 500m/512Mi
+	// for small, 250m/256Mi and 1/1Gi for medium, 500m/512Mi and 2/2Gi for
+	// large.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=small;medium;large
+	ResourceProfile string `json:"resourceProfile,omitempty"`
 
 	// +kubebuilder:validation:Required
 	SecurityContext *corev1.PodSecurityContext `json:"securityContext"`
 
+	// PodAnnotations are added to the controller pods, for example
+	// sidecar.istio.io/inject to control mesh injection.
+	// +kubebuilder:validation:Optional
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+
+	// SchedulingGates keep new controller pods from being scheduled until an
+	// external controller removes them, e.g. for capacity provisioning.
+	// +kubebuilder:validation:Optional
+	SchedulingGates []corev1.PodSchedulingGate `json:"schedulingGates,omitempty"`
+
 	// +kubebuilder:validation:Required
 	Service *ServiceSpec `json:"service"`
 
@@ -54,6 +77,8 @@ type ArgoWorkFlowSpec struct {
 	// +kubebuilder:validation:Optional
 	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
 
+	// Affinity of the controller pods. When unset and more than one replica
+	// runs, the replicas preferably spread across nodes.
 	// +kubebuilder:validation:Optional
 	Affinity *corev1.Affinity `json:"affinity"`
 
@@ -63,6 +88,196 @@ type ArgoWorkFlowSpec struct {
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default:=true
 	ServiceAccount bool `json:"serviceAccount"`
+
+	// +kubebuilder:validation:Optional
+	Server *ServerSpec `json:"server,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	ConfigMap *ConfigMapSpec `json:"configMap,omitempty"`
+
+	// DegradedThreshold is the number of consecutive failed reconciles after
+	// which the Degraded condition is set.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=3
+	DegradedThreshold int32 `json:"degradedThreshold,omitempty"`
+
+	// ReconcileTimeout bounds a single reconcile pass. A pass that runs out
+	// of time is retried and reported in the Reconcile condition.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="2m"
+	ReconcileTimeout *metav1.Duration `json:"reconcileTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	ControllerConfig *ControllerConfigSpec `json:"controllerConfig,omitempty"`
+
+	// ArtifactRepositories are the artifact repositories available to
+	// workflows, keyed by name. The controller config holds a single
+	// repository, so only DefaultArtifactRepository is rendered into it. All
+	// of them are published in the "artifact-repositories" ConfigMap of the
+	// namespace for workflows to reference.
+	// +kubebuilder:validation:Optional
+	ArtifactRepositories map[string]ArtifactRepositorySpec `json:"artifactRepositories,omitempty"`
+
+	// DefaultArtifactRepository is the key of the repository in
+	// ArtifactRepositories rendered as the controller's artifactRepository
+	// and annotated as default on the artifact-repositories ConfigMap.
+	// +kubebuilder:validation:Optional
+	DefaultArtifactRepository string `json:"defaultArtifactRepository,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Metrics *MetricsSpec `json:"metrics,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default:=10
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// PruneReplicaSets deletes scaled down ReplicaSets of the controller
+	// Deployment beyond RevisionHistoryLimit. ReplicaSets with replicas are
+	// never deleted.
+	// +kubebuilder:validation:Optional
+	PruneReplicaSets bool `json:"pruneReplicaSets,omitempty"`
+
+	// ProgressDeadlineSeconds is how long the Deployment may take to roll out
+	// before the rollout, and the Progressing condition, is reported as failed.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=600
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+
+	// Strategy overrides the Deployment update strategy. When unset, a
+	// single replica controller with leader election uses Recreate so two
+	// controllers never run during a rollout, otherwise RollingUpdate.
+	// +kubebuilder:validation:Optional
+	Strategy *appsv1.DeploymentStrategy `json:"strategy,omitempty"`
+
+	// IgnoreManualScale keeps a replica count set on the controller
+	// Deployment directly, e.g. with kubectl scale, until spec.replicas
+	// changes. By default the operator restores spec.replicas and records an
+	// event.
+	// +kubebuilder:validation:Optional
+	IgnoreManualScale bool `json:"ignoreManualScale,omitempty"`
+
+	// AllowSelectorMigration lets the operator delete and recreate the
+	// controller Deployment when its immutable selector has to change, e.g.
+	// after the ArgoWorkFlow labels changed. The controller is down until the
+	// new pods are ready.
+	// +kubebuilder:validation:Optional
+	AllowSelectorMigration bool `json:"allowSelectorMigration,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	LeaderElection *LeaderElectionSpec `json:"leaderElection,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Controller *ControllerSpec `json:"controller,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	RBAC *RBACSpec `json:"rbac,omitempty"`
+
+	// NamespaceParallelismOverrides is not applied: the workflow-controller
+	// only has a single namespaceParallelism limit for all namespaces. Setting
+	// it raises the UnsupportedConfig condition; run separate ArgoWorkFlow
+	// instances with managed namespaces to get different limits.
+	// +kubebuilder:validation:Optional
+	NamespaceParallelismOverrides map[string]int32 `json:"namespaceParallelismOverrides,omitempty"`
+
+	// LogLevel is the default log level of the managed components.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=debug;info;warn;error
+	// +kubebuilder:default:=info
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// EventBusRef is the name of an Argo Events EventBus in the namespace of
+	// the ArgoWorkFlow that workflows are triggered through. Its presence is
+	// reported in the EventBusAvailable condition.
+	// +kubebuilder:validation:Optional
+	EventBusRef string `json:"eventBusRef,omitempty"`
+
+	// PreDeleteHook runs a Workflow before the ArgoWorkFlow is removed.
+	// +kubebuilder:validation:Optional
+	PreDeleteHook *PreDeleteHookSpec `json:"preDeleteHook,omitempty"`
+}
+
+// PreDeleteHookSpec runs a Workflow when the ArgoWorkFlow is deleted. The
+// managed controller stays up until the Workflow finished or timed out.
+type PreDeleteHookSpec struct {
+	// WorkflowTemplateRef is the WorkflowTemplate the Workflow is created
+	// from, in the namespace of the ArgoWorkFlow.
+	// +kubebuilder:validation:Required
+	WorkflowTemplateRef string `json:"workflowTemplateRef"`
+
+	// TimeoutSeconds bounds the wait for the Workflow, counted from the
+	// first attempt to run it. Deletion proceeds once it is exceeded, also
+	// when the Workflow could not be created at all.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=300
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+type RBACSpec struct {
+	// CreateClusterRole makes the operator create a ClusterRole with the
+	// permissions the workflow-controller needs and bind the controller to
+	// it, instead of binding the operator's manager-role.
+	// +kubebuilder:validation:Optional
+	CreateClusterRole bool `json:"createClusterRole,omitempty"`
+
+	// ExtraRules are granted to the workflow ServiceAccount through a Role in
+	// the namespace of the ArgoWorkFlow. The ServiceAccount is the workflow
+	// default one, or the namespace default ServiceAccount when none is set.
+	// The Role always grants the workflowtasksets access the agent of HTTP
+	// and plugin templates needs.
+	// Only workflow objects of argoproj.io and the core pods, pods/log,
+	// configmaps, persistentvolumeclaims and events may be granted, anyone
+	// allowed to edit the ArgoWorkFlow can grant them.
+	// +kubebuilder:validation:Optional
+	ExtraRules []rbacv1.PolicyRule `json:"extraRules,omitempty"`
+}
+
+// ControllerSpec holds settings of the workflow-controller container.
+type ControllerSpec struct {
+	// ExtraPorts are added to the controller container, e.g. for plugins.
+	// +kubebuilder:validation:Optional
+	ExtraPorts []corev1.ContainerPort `json:"extraPorts,omitempty"`
+
+	// ExposeExtraPorts also adds the extra ports to the controller Service.
+	// +kubebuilder:validation:Optional
+	ExposeExtraPorts bool `json:"exposeExtraPorts,omitempty"`
+
+	// Command replaces the controller container entrypoint. Meant for
+	// debugging.
+	// +kubebuilder:validation:Optional
+	Command []string `json:"command,omitempty"`
+
+	// Args replace the operator managed controller arguments entirely,
+	// including --configmap and the worker settings. Meant for debugging.
+	// +kubebuilder:validation:Optional
+	Args []string `json:"args,omitempty"`
+
+	// LogLevel of the controller, overriding the top-level logLevel.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=debug;info;warn;error
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// Lifecycle of the controller container. When unset and leader election
+	// is enabled, a short preStop sleep lets the old pod drain during a
+	// rollout.
+	// +kubebuilder:validation:Optional
+	Lifecycle *corev1.Lifecycle `json:"lifecycle,omitempty"`
+}
+
+type LeaderElectionSpec struct {
+	// Enabled controls the controller leader election, which Argo enables by
+	// default.
+	// +kubebuilder:validation:Optional
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// PreCreateLease creates the controller Lease up front, for clusters
+	// where the controller ServiceAccount may not create Leases. The
+	// controller then only updates it.
+	// +kubebuilder:validation:Optional
+	PreCreateLease bool `json:"preCreateLease,omitempty"`
 }
 
 func (argoWorkflow *ArgoWorkFlow) GetNameWithSuffix(suffix string) string {
@@ -94,6 +309,368 @@ type ServiceSpec struct {
 	Port int32 `json:"port"`
 }
 
+// ServerSpec holds the argo-server (UI) settings. They are read by the server
+// from the controller ConfigMap.
+type ServerSpec struct {
+	// +kubebuilder:validation:Optional
+	Links []LinkSpec `json:"links,omitempty"`
+	// +kubebuilder:validation:Optional
+	Columns []ColumnSpec `json:"columns,omitempty"`
+	// NodeLinks are links shown for workflow nodes, e.g. to logs or metrics
+	// dashboards. They are rendered together with Links.
+	// +kubebuilder:validation:Optional
+	NodeLinks []NodeLinkSpec `json:"nodeLinks,omitempty"`
+}
+
+// LinkSpec is an external link shown in the UI. The URL may reference workflow
+// fields with ${...} placeholders, e.g. ${metadata.namespace}.
+type LinkSpec struct {
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+	// Scope is where the UI shows the link: on workflows, the workflow list,
+	// pods, pod, event source or sensor logs, or as the chat link.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Enum=workflow;workflow-list;pod;pod-logs;event-source-logs;sensor-logs;chat
+	Scope string `json:"scope"`
+	// +kubebuilder:validation:Required
+	URL string `json:"url"`
+}
+
+type NodeLinkSpec struct {
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Enum=pod;workflow
+	Scope string `json:"scope"`
+	// +kubebuilder:validation:Required
+	URL string `json:"url"`
+}
+
+// ColumnSpec is an additional column in the UI workflow list, filled from a
+// workflow label or annotation.
+type ColumnSpec struct {
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Enum=label;annotation
+	Type string `json:"type"`
+	// +kubebuilder:validation:Required
+	Key string `json:"key"`
+}
+
+type ConfigMapSpec struct {
+	// Name of the generated controller ConfigMap, defaults to
+	// "<name>-controller". The previous ConfigMap is deleted on rename.
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+
+	// MirrorName, when set, makes the operator keep an immutable backup copy
+	// of the controller ConfigMap under this name. The copy is recreated
+	// whenever the controller config changes. Must differ from Name.
+	// +kubebuilder:validation:Optional
+	MirrorName string `json:"mirrorName,omitempty"`
+}
+
+// ControllerConfigSpec holds settings rendered into the workflow-controller
+// configuration.
+type ControllerConfigSpec struct {
+	// +kubebuilder:validation:Optional
+	WorkflowEvents *WorkflowEventsSpec `json:"workflowEvents,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Executor *ExecutorSpec `json:"executor,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Telemetry *TelemetrySpec `json:"telemetry,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	PProf *PProfSpec `json:"pprof,omitempty"`
+
+	// InstanceID limits the controller to workflows labeled with
+	// workflows.argoproj.io/controller-instanceid set to this value. Defaults
+	// to <namespace>-<name>, so every ArgoWorkFlow in a cluster gets a
+	// distinct one; an explicit ID shared with another instance is reported
+	// in the InstanceIDConflict condition.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:MaxLength=63
+	InstanceID string `json:"instanceID,omitempty"`
+
+	// HotReload stops config changes from restarting the controller pods and
+	// relies on the controller watching its ConfigMap, which Argo v3
+	// controllers do. Settings Argo only reads at startup then need a manual
+	// restart.
+	// +kubebuilder:validation:Optional
+	HotReload bool `json:"hotReload,omitempty"`
+
+	// ExistingConfigMap is the name of a user managed ConfigMap the controller
+	// reads its configuration from. When set, the operator does not generate
+	// the controller ConfigMap and all other config settings are ignored.
+	// Changes to the ConfigMap roll the controller pods unless HotReload is
+	// set.
+	// +kubebuilder:validation:Optional
+	ExistingConfigMap string `json:"existingConfigMap,omitempty"`
+
+	// BaseConfigMap is the name of a ConfigMap whose "config" key is used as
+	// the base of the generated controller config. The operator generated
+	// settings are merged onto it, nested maps key by key.
+	// +kubebuilder:validation:Optional
+	BaseConfigMap string `json:"baseConfigMap,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=32
+	WorkflowWorkers int32 `json:"workflowWorkers,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	PodWorkers int32 `json:"podWorkers,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	PodCleanupWorkers int32 `json:"podCleanupWorkers,omitempty"`
+
+	// WorkflowTTLWorkers is the number of workers deleting workflows whose
+	// TTL expired.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	WorkflowTTLWorkers int32 `json:"workflowTTLWorkers,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	WorkflowRestrictions *WorkflowRestrictionsSpec `json:"workflowRestrictions,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	WorkflowDefaults *WorkflowDefaultsSpec `json:"workflowDefaults,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	RetentionPolicy *RetentionPolicySpec `json:"retentionPolicy,omitempty"`
+
+	// KubeconfigSecret is the name of a Secret holding a "kubeconfig" key. It
+	// is mounted into the controller pod and passed via --kubeconfig, so the
+	// controller talks to the cluster described there instead of its own.
+	// +kubebuilder:validation:Optional
+	KubeconfigSecret string `json:"kubeconfigSecret,omitempty"`
+
+	// ConfigOverlay is merged over the operator generated controller config.
+	// Nested maps are merged key by key, any other value in the overlay,
+	// including lists, replaces the generated one.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:pruning:PreserveUnknownFields
+	ConfigOverlay *runtime.RawExtension `json:"configOverlay,omitempty"`
+}
+
+// TelemetrySpec configures the OpenTelemetry export of the controller.
+type TelemetrySpec struct {
+	// OTLPEndpoint is the http or https URL of the OpenTelemetry collector
+	// the controller exports to, passed as OTEL_EXPORTER_OTLP_ENDPOINT. Argo
+	// reads it from v3.6.0 on; older images, the default one included, ignore
+	// it and the UnsupportedConfig condition is set.
+	// +kubebuilder:validation:Optional
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"`
+}
+
+type PProfSpec struct {
+	// Enabled serves the Go pprof endpoints of the controller on port 6060,
+	// also exposed on the controller Service. The endpoints are not
+	// authenticated, only enable them for debugging.
+	// +kubebuilder:validation:Optional
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// ExecutorSpec configures the executor containers Argo adds to workflow pods.
+type ExecutorSpec struct {
+	// ImagePullPolicy of the init and wait containers. The controller
+	// default, IfNotPresent, is used when unset.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+}
+
+// RetentionPolicySpec caps how many finished workflows of each phase the
+// controller keeps. Argo keeps all of them when unset.
+type RetentionPolicySpec struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	Completed *int32 `json:"completed,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	Failed *int32 `json:"failed,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	Errored *int32 `json:"errored,omitempty"`
+}
+
+// WorkflowDefaultsSpec holds defaults applied to every workflow spec.
+type WorkflowDefaultsSpec struct {
+	// PodPriorityClassName is the PriorityClass of the workflow pods. The
+	// PriorityClass must exist.
+	// +kubebuilder:validation:Optional
+	PodPriorityClassName string `json:"podPriorityClassName,omitempty"`
+
+	// PodMetadata is added to every workflow pod, e.g. for cost allocation.
+	// +kubebuilder:validation:Optional
+	PodMetadata *PodMetadataSpec `json:"podMetadata,omitempty"`
+
+	// PodSpecPatch is a JSON or YAML patch applied to the spec of every
+	// workflow pod, e.g. to inject sidecars or resources.
+	// +kubebuilder:validation:Optional
+	PodSpecPatch string `json:"podSpecPatch,omitempty"`
+
+	// ServiceAccountName is used by workflows that do not set one. Argo uses
+	// the namespace default ServiceAccount when unset. The ServiceAccount
+	// must exist in the namespace of the ArgoWorkFlow.
+	// +kubebuilder:validation:Optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// ActiveDeadlineSeconds caps the run time of workflows that do not set
+	// their own deadline.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+
+	// Volumes are added to every workflow, e.g. a ConfigMap with trusted CA
+	// certificates. Referenced ConfigMaps and Secrets must exist in each
+	// namespace workflows run in.
+	// +kubebuilder:validation:Optional
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+
+	// VolumeMounts are added to the container of every container template.
+	// They must refer to one of Volumes.
+	// +kubebuilder:validation:Optional
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+
+	// TemplateDefaults is a template fragment applied to every workflow
+	// template, e.g. a retryStrategy or timeout.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:pruning:PreserveUnknownFields
+	TemplateDefaults *runtime.RawExtension `json:"templateDefaults,omitempty"`
+}
+
+type PodMetadataSpec struct {
+	// +kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type WorkflowRestrictionsSpec struct {
+	// TemplateReferencing requires workflows to reference a WorkflowTemplate.
+	// Strict only runs workflows using workflowTemplateRef, Secure also fails
+	// workflows whose referenced template changed since they started.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Strict;Secure
+	TemplateReferencing string `json:"templateReferencing,omitempty"`
+}
+
+type WorkflowEventsSpec struct {
+	// Enabled controls whether the controller emits workflow lifecycle
+	// events. Argo enables them by default.
+	// +kubebuilder:validation:Optional
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+type ArtifactRepositorySpec struct {
+	// +kubebuilder:validation:Optional
+	ArchiveLogs *bool `json:"archiveLogs,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	S3 *S3ArtifactRepositorySpec `json:"s3,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	HealthCheck *ArtifactHealthCheckSpec `json:"healthCheck,omitempty"`
+}
+
+// ArtifactHealthCheckSpec configures a background probe of the artifact
+// repository endpoint. The results of all probed repositories are reported
+// in the ArtifactRepoReachable condition. It needs network egress from the
+// operator.
+type ArtifactHealthCheckSpec struct {
+	// +kubebuilder:validation:Optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=5
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// CABundle holds PEM encoded CA certificates the probe trusts in addition
+	// to the system roots, for endpoints with a private CA.
+	// +kubebuilder:validation:Optional
+	CABundle string `json:"caBundle,omitempty"`
+}
+
+type S3ArtifactRepositorySpec struct {
+	// +kubebuilder:validation:Required
+	Endpoint string `json:"endpoint"`
+	// +kubebuilder:validation:Required
+	Bucket string `json:"bucket"`
+	// +kubebuilder:validation:Optional
+	Region string `json:"region,omitempty"`
+	// +kubebuilder:validation:Optional
+	Insecure *bool `json:"insecure,omitempty"`
+	// +kubebuilder:validation:Optional
+	KeyFormat string `json:"keyFormat,omitempty"`
+	// +kubebuilder:validation:Optional
+	AccessKeySecret *corev1.SecretKeySelector `json:"accessKeySecret,omitempty"`
+	// +kubebuilder:validation:Optional
+	SecretKeySecret *corev1.SecretKeySelector `json:"secretKeySecret,omitempty"`
+
+	// CreateSecret makes the operator store these credentials in a Secret it
+	// owns and reference it where AccessKeySecret/SecretKeySecret are unset.
+	// Anyone who can read the ArgoWorkFlow can read the credentials, so
+	// referencing an existing Secret is preferred.
+	// +kubebuilder:validation:Optional
+	CreateSecret *S3CredentialsSpec `json:"createSecret,omitempty"`
+}
+
+type S3CredentialsSpec struct {
+	// +kubebuilder:validation:Required
+	AccessKey string `json:"accessKey"`
+	// +kubebuilder:validation:Required
+	SecretKey string `json:"secretKey"`
+}
+
+type MetricsSpec struct {
+	// Secure serves the controller metrics over TLS. Without TLSSecret the
+	// controller uses a self-signed certificate.
+	// +kubebuilder:validation:Optional
+	Secure *bool `json:"secure,omitempty"`
+
+	// TLSSecret is the name of a Secret holding "tls.crt" and "tls.key" keys,
+	// used when Secure is set. It is mounted into the controller pod and the
+	// certificate and key paths are passed in the METRICS_TLS_CERT_FILE and
+	// METRICS_TLS_KEY_FILE environment variables. Images that do not read
+	// them keep serving a self-signed certificate.
+	// +kubebuilder:validation:Optional
+	TLSSecret string `json:"tlsSecret,omitempty"`
+
+	// Port is used for the metrics endpoint in the controller config, the
+	// container and the Service alike.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default:=9090
+	Port int32 `json:"port,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:="/metrics"
+	Path string `json:"path,omitempty"`
+
+	// IgnoreErrors keeps the controller running when a custom metric fails
+	// to be emitted.
+	// +kubebuilder:validation:Optional
+	IgnoreErrors *bool `json:"ignoreErrors,omitempty"`
+
+	// SeparateService creates a "<name>-metrics" Service exposing only the
+	// metrics port, for scrapers that select metrics-only Services. It is
+	// labeled app.kubernetes.io/component=metrics and its port is named
+	// "metrics".
+	// +kubebuilder:validation:Optional
+	SeparateService bool `json:"separateService,omitempty"`
+}
+
 // SetStatusCondition updates the status condition using the provided arguments.
 // If the condition already exists, it updates the condition; otherwise, it appends the condition.
 // If the condition status has changed, it updates the condition's LastTransitionTime.
@@ -138,10 +715,66 @@ func (argoWorkflow *ArgoWorkFlow) InitStatusConditions() {
 type ArgoWorkFlowStatus struct {
 	// +kubebuilder:validation:Optional
 	Conditions []metav1.Condition `json:"condition,omitempty"`
+
+	// ConsecutiveFailures counts the failed reconciles in a row of the current
+	// generation, a spec change starts over from zero.
+	// +kubebuilder:validation:Optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// ConfigHash is the checksum of the controller config, matching the
+	// config checksum annotation on the controller pod template.
+	// +kubebuilder:validation:Optional
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// ZoneReadiness is the number of ready controller pods per topology zone.
+	// +kubebuilder:validation:Optional
+	ZoneReadiness map[string]int32 `json:"zoneReadiness,omitempty"`
+
+	// ConfigMapName is the name of the controller ConfigMap generated by the
+	// operator, used to clean up the old ConfigMap after a rename. The Service
+	// and ServiceAccount are named after the ArgoWorkFlow itself, whose name
+	// cannot change, so they are never renamed and need no such tracking.
+	// +kubebuilder:validation:Optional
+	ConfigMapName string `json:"configMapName,omitempty"`
+
+	// ArtifactRepositoryType is the backend of the default artifact
+	// repository: s3, none, or unknown with an existing controller ConfigMap.
+	// +kubebuilder:validation:Optional
+	ArtifactRepositoryType string `json:"artifactRepositoryType,omitempty"`
+
+	// ArgoVersion is the Argo release parsed from the controller image tag,
+	// "unknown" for digests and tags such as latest.
+	// +kubebuilder:validation:Optional
+	ArgoVersion string `json:"argoVersion,omitempty"`
+
+	// DriftDetected is set when the last reconcile had to create, update or
+	// delete a managed object, because the spec changed or the object was
+	// modified or deleted outside of the operator.
+	// +kubebuilder:validation:Optional
+	DriftDetected bool `json:"driftDetected,omitempty"`
+
+	// LastDriftTime is when a managed object was last created, updated or
+	// deleted.
+	// +kubebuilder:validation:Optional
+	LastDriftTime *metav1.Time `json:"lastDriftTime,omitempty"`
+
+	// PProfPort is the port the controller serves pprof on, unset while
+	// pprof is disabled.
+	// +kubebuilder:validation:Optional
+	PProfPort int32 `json:"pprofPort,omitempty"`
+
+	// PreDeleteHookStartTime is when the operator first tried to run the
+	// pre-delete hook, the hook timeout is counted from it.
+	// +kubebuilder:validation:Optional
+	PreDeleteHookStartTime *metav1.Time `json:"preDeleteHookStartTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Argo Version",type=string,JSONPath=".status.argoVersion"
+//+kubebuilder:printcolumn:name="Artifacts",type=string,JSONPath=".status.artifactRepositoryType"
+//+kubebuilder:printcolumn:name="Terminating",type=string,JSONPath=".status.condition[?(@.type==\"Terminating\")].message"
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
 
 // ArgoWorkFlow is the Schema for the argoworkflows API
 type ArgoWorkFlow struct {
@@ -4,6 +4,18 @@ const (
 	ConditionTypeProgressing string = "Progressing"
 	ConditionTypeReconcile   string = "Reconcile"
 	ConditionTypeAvailable   string = "Available"
+	ConditionTypeDegraded    string = "Degraded"
+	ConditionTypeMigration   string = "MigrationNeeded"
+	ConditionTypeArtifacts   string = "ArtifactRepositoryReady"
+	ConditionTypeReachable   string = "ArtifactRepoReachable"
+	ConditionTypeImmutable   string = "ConfigMapImmutable"
+	ConditionTypeUnsupported string = "UnsupportedConfig"
+	ConditionTypePreDelete   string = "PreDeleteHook"
+	ConditionTypeQuota       string = "QuotaExceeded"
+	ConditionTypeTerminating string = "Terminating"
+	ConditionTypeInstanceID  string = "InstanceIDConflict"
+	ConditionTypeEventBus    string = "EventBusAvailable"
+	ConditionTypeMetricsTLS  string = "MetricsTLSReady"
 
 	ConditionReasonPreparing           string = "Preparing"
 	ConditionReasonRunning             string = "Running"
@@ -12,4 +24,34 @@ const (
 	ConditionReasonReconcileService    string = "ReconcileService"
 	ConditionReasonReconcileIngress    string = "ReconcileIngress"
 	ConditionReasonReconcileDeployment string = "ReconcileDeployment"
+	ConditionReasonReconcileFailed     string = "ReconcileFailed"
+	ConditionReasonReconcileSucceeded  string = "ReconcileSucceeded"
+	ConditionReasonProgressDeadline    string = "ProgressDeadlineExceeded"
+	ConditionReasonCRDsOutdated        string = "ArgoCRDsOutdated"
+	ConditionReasonCRDsUpToDate        string = "ArgoCRDsUpToDate"
+	ConditionReasonSecretMissing       string = "ArtifactSecretMissing"
+	ConditionReasonSecretsFound        string = "ArtifactSecretsFound"
+	ConditionReasonProbeSucceeded      string = "ProbeSucceeded"
+	ConditionReasonProbeFailed         string = "ProbeFailed"
+	ConditionReasonImmutable           string = "ImmutableConfigMap"
+	ConditionReasonNamespaceOverrides  string = "NamespaceParallelismOverrides"
+	ConditionReasonOTLPUnsupported     string = "OTLPEndpointUnsupported"
+	ConditionReasonConfigRollout       string = "WaitingForConfigRollout"
+	ConditionReasonHookRunning         string = "HookRunning"
+	ConditionReasonHookSucceeded       string = "HookSucceeded"
+	ConditionReasonHookFailed          string = "HookFailed"
+	ConditionReasonHookTimedOut        string = "HookTimedOut"
+	ConditionReasonHookSkipped         string = "HookSkipped"
+	ConditionReasonQuotaExceeded       string = "ResourceQuotaExceeded"
+	ConditionReasonWithinQuota         string = "WithinResourceQuota"
+	ConditionReasonCleanupInProgress   string = "CleanupInProgress"
+	ConditionReasonCleanupFailed       string = "CleanupFailed"
+	ConditionReasonDuplicateInstanceID string = "DuplicateInstanceID"
+	ConditionReasonUniqueInstanceID    string = "UniqueInstanceID"
+	ConditionReasonReconcileTimedOut   string = "ReconcileTimedOut"
+	ConditionReasonEventBusFound       string = "EventBusFound"
+	ConditionReasonEventBusMissing     string = "EventBusMissing"
+	ConditionReasonEventsNotInstalled  string = "ArgoEventsNotInstalled"
+	ConditionReasonTLSSecretMissing    string = "MetricsTLSSecretMissing"
+	ConditionReasonTLSSecretFound      string = "MetricsTLSSecretFound"
 )
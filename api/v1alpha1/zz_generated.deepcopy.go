@@ -21,9 +21,11 @@ limitations under the License.
 package v1alpha1
 
 import (
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -103,6 +105,18 @@ func (in *ArgoWorkFlowSpec) DeepCopyInto(out *ArgoWorkFlowSpec) {
 		*out = new(v1.PodSecurityContext)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.PodAnnotations != nil {
+		in, out := &in.PodAnnotations, &out.PodAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.SchedulingGates != nil {
+		in, out := &in.SchedulingGates, &out.SchedulingGates
+		*out = make([]v1.PodSchedulingGate, len(*in))
+		copy(*out, *in)
+	}
 	if in.Service != nil {
 		in, out := &in.Service, &out.Service
 		*out = new(ServiceSpec)
@@ -139,6 +153,80 @@ func (in *ArgoWorkFlowSpec) DeepCopyInto(out *ArgoWorkFlowSpec) {
 		*out = new(v1.Toleration)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Server != nil {
+		in, out := &in.Server, &out.Server
+		*out = new(ServerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigMap != nil {
+		in, out := &in.ConfigMap, &out.ConfigMap
+		*out = new(ConfigMapSpec)
+		**out = **in
+	}
+	if in.ReconcileTimeout != nil {
+		in, out := &in.ReconcileTimeout, &out.ReconcileTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.ControllerConfig != nil {
+		in, out := &in.ControllerConfig, &out.ControllerConfig
+		*out = new(ControllerConfigSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ArtifactRepositories != nil {
+		in, out := &in.ArtifactRepositories, &out.ArtifactRepositories
+		*out = make(map[string]ArtifactRepositorySpec, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = new(MetricsSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RevisionHistoryLimit != nil {
+		in, out := &in.RevisionHistoryLimit, &out.RevisionHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ProgressDeadlineSeconds != nil {
+		in, out := &in.ProgressDeadlineSeconds, &out.ProgressDeadlineSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Strategy != nil {
+		in, out := &in.Strategy, &out.Strategy
+		*out = new(appsv1.DeploymentStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LeaderElection != nil {
+		in, out := &in.LeaderElection, &out.LeaderElection
+		*out = new(LeaderElectionSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Controller != nil {
+		in, out := &in.Controller, &out.Controller
+		*out = new(ControllerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RBAC != nil {
+		in, out := &in.RBAC, &out.RBAC
+		*out = new(RBACSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NamespaceParallelismOverrides != nil {
+		in, out := &in.NamespaceParallelismOverrides, &out.NamespaceParallelismOverrides
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PreDeleteHook != nil {
+		in, out := &in.PreDeleteHook, &out.PreDeleteHook
+		*out = new(PreDeleteHookSpec)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArgoWorkFlowSpec.
@@ -161,6 +249,21 @@ func (in *ArgoWorkFlowStatus) DeepCopyInto(out *ArgoWorkFlowStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ZoneReadiness != nil {
+		in, out := &in.ZoneReadiness, &out.ZoneReadiness
+		*out = make(map[string]int32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.LastDriftTime != nil {
+		in, out := &in.LastDriftTime, &out.LastDriftTime
+		*out = (*in).DeepCopy()
+	}
+	if in.PreDeleteHookStartTime != nil {
+		in, out := &in.PreDeleteHookStartTime, &out.PreDeleteHookStartTime
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArgoWorkFlowStatus.
@@ -173,6 +276,186 @@ func (in *ArgoWorkFlowStatus) DeepCopy() *ArgoWorkFlowStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArtifactHealthCheckSpec) DeepCopyInto(out *ArtifactHealthCheckSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArtifactHealthCheckSpec.
+func (in *ArtifactHealthCheckSpec) DeepCopy() *ArtifactHealthCheckSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ArtifactHealthCheckSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArtifactRepositorySpec) DeepCopyInto(out *ArtifactRepositorySpec) {
+	*out = *in
+	if in.ArchiveLogs != nil {
+		in, out := &in.ArchiveLogs, &out.ArchiveLogs
+		*out = new(bool)
+		**out = **in
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3ArtifactRepositorySpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(ArtifactHealthCheckSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArtifactRepositorySpec.
+func (in *ArtifactRepositorySpec) DeepCopy() *ArtifactRepositorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ArtifactRepositorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ColumnSpec) DeepCopyInto(out *ColumnSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ColumnSpec.
+func (in *ColumnSpec) DeepCopy() *ColumnSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ColumnSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigMapSpec) DeepCopyInto(out *ConfigMapSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigMapSpec.
+func (in *ConfigMapSpec) DeepCopy() *ConfigMapSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigMapSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControllerConfigSpec) DeepCopyInto(out *ControllerConfigSpec) {
+	*out = *in
+	if in.WorkflowEvents != nil {
+		in, out := &in.WorkflowEvents, &out.WorkflowEvents
+		*out = new(WorkflowEventsSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Executor != nil {
+		in, out := &in.Executor, &out.Executor
+		*out = new(ExecutorSpec)
+		**out = **in
+	}
+	if in.Telemetry != nil {
+		in, out := &in.Telemetry, &out.Telemetry
+		*out = new(TelemetrySpec)
+		**out = **in
+	}
+	if in.PProf != nil {
+		in, out := &in.PProf, &out.PProf
+		*out = new(PProfSpec)
+		**out = **in
+	}
+	if in.WorkflowRestrictions != nil {
+		in, out := &in.WorkflowRestrictions, &out.WorkflowRestrictions
+		*out = new(WorkflowRestrictionsSpec)
+		**out = **in
+	}
+	if in.WorkflowDefaults != nil {
+		in, out := &in.WorkflowDefaults, &out.WorkflowDefaults
+		*out = new(WorkflowDefaultsSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RetentionPolicy != nil {
+		in, out := &in.RetentionPolicy, &out.RetentionPolicy
+		*out = new(RetentionPolicySpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigOverlay != nil {
+		in, out := &in.ConfigOverlay, &out.ConfigOverlay
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControllerConfigSpec.
+func (in *ControllerConfigSpec) DeepCopy() *ControllerConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ControllerConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControllerSpec) DeepCopyInto(out *ControllerSpec) {
+	*out = *in
+	if in.ExtraPorts != nil {
+		in, out := &in.ExtraPorts, &out.ExtraPorts
+		*out = make([]v1.ContainerPort, len(*in))
+		copy(*out, *in)
+	}
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Args != nil {
+		in, out := &in.Args, &out.Args
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Lifecycle != nil {
+		in, out := &in.Lifecycle, &out.Lifecycle
+		*out = new(v1.Lifecycle)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControllerSpec.
+func (in *ControllerSpec) DeepCopy() *ControllerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ControllerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExecutorSpec) DeepCopyInto(out *ExecutorSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExecutorSpec.
+func (in *ExecutorSpec) DeepCopy() *ExecutorSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ExecutorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImageSpec) DeepCopyInto(out *ImageSpec) {
 	*out = *in
@@ -188,6 +471,272 @@ func (in *ImageSpec) DeepCopy() *ImageSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LeaderElectionSpec) DeepCopyInto(out *LeaderElectionSpec) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LeaderElectionSpec.
+func (in *LeaderElectionSpec) DeepCopy() *LeaderElectionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LeaderElectionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LinkSpec) DeepCopyInto(out *LinkSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LinkSpec.
+func (in *LinkSpec) DeepCopy() *LinkSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LinkSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsSpec) DeepCopyInto(out *MetricsSpec) {
+	*out = *in
+	if in.Secure != nil {
+		in, out := &in.Secure, &out.Secure
+		*out = new(bool)
+		**out = **in
+	}
+	if in.IgnoreErrors != nil {
+		in, out := &in.IgnoreErrors, &out.IgnoreErrors
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSpec.
+func (in *MetricsSpec) DeepCopy() *MetricsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeLinkSpec) DeepCopyInto(out *NodeLinkSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeLinkSpec.
+func (in *NodeLinkSpec) DeepCopy() *NodeLinkSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeLinkSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PProfSpec) DeepCopyInto(out *PProfSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PProfSpec.
+func (in *PProfSpec) DeepCopy() *PProfSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PProfSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodMetadataSpec) DeepCopyInto(out *PodMetadataSpec) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodMetadataSpec.
+func (in *PodMetadataSpec) DeepCopy() *PodMetadataSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PodMetadataSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PreDeleteHookSpec) DeepCopyInto(out *PreDeleteHookSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreDeleteHookSpec.
+func (in *PreDeleteHookSpec) DeepCopy() *PreDeleteHookSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PreDeleteHookSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RBACSpec) DeepCopyInto(out *RBACSpec) {
+	*out = *in
+	if in.ExtraRules != nil {
+		in, out := &in.ExtraRules, &out.ExtraRules
+		*out = make([]rbacv1.PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RBACSpec.
+func (in *RBACSpec) DeepCopy() *RBACSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RBACSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RetentionPolicySpec) DeepCopyInto(out *RetentionPolicySpec) {
+	*out = *in
+	if in.Completed != nil {
+		in, out := &in.Completed, &out.Completed
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Failed != nil {
+		in, out := &in.Failed, &out.Failed
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Errored != nil {
+		in, out := &in.Errored, &out.Errored
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RetentionPolicySpec.
+func (in *RetentionPolicySpec) DeepCopy() *RetentionPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RetentionPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3ArtifactRepositorySpec) DeepCopyInto(out *S3ArtifactRepositorySpec) {
+	*out = *in
+	if in.Insecure != nil {
+		in, out := &in.Insecure, &out.Insecure
+		*out = new(bool)
+		**out = **in
+	}
+	if in.AccessKeySecret != nil {
+		in, out := &in.AccessKeySecret, &out.AccessKeySecret
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecretKeySecret != nil {
+		in, out := &in.SecretKeySecret, &out.SecretKeySecret
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CreateSecret != nil {
+		in, out := &in.CreateSecret, &out.CreateSecret
+		*out = new(S3CredentialsSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3ArtifactRepositorySpec.
+func (in *S3ArtifactRepositorySpec) DeepCopy() *S3ArtifactRepositorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(S3ArtifactRepositorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3CredentialsSpec) DeepCopyInto(out *S3CredentialsSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3CredentialsSpec.
+func (in *S3CredentialsSpec) DeepCopy() *S3CredentialsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(S3CredentialsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServerSpec) DeepCopyInto(out *ServerSpec) {
+	*out = *in
+	if in.Links != nil {
+		in, out := &in.Links, &out.Links
+		*out = make([]LinkSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.Columns != nil {
+		in, out := &in.Columns, &out.Columns
+		*out = make([]ColumnSpec, len(*in))
+		copy(*out, *in)
+	}
+	if in.NodeLinks != nil {
+		in, out := &in.NodeLinks, &out.NodeLinks
+		*out = make([]NodeLinkSpec, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServerSpec.
+func (in *ServerSpec) DeepCopy() *ServerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ServerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceSpec) DeepCopyInto(out *ServiceSpec) {
 	*out = *in
@@ -209,3 +758,97 @@ func (in *ServiceSpec) DeepCopy() *ServiceSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TelemetrySpec) DeepCopyInto(out *TelemetrySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TelemetrySpec.
+func (in *TelemetrySpec) DeepCopy() *TelemetrySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TelemetrySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkflowDefaultsSpec) DeepCopyInto(out *WorkflowDefaultsSpec) {
+	*out = *in
+	if in.PodMetadata != nil {
+		in, out := &in.PodMetadata, &out.PodMetadata
+		*out = new(PodMetadataSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ActiveDeadlineSeconds != nil {
+		in, out := &in.ActiveDeadlineSeconds, &out.ActiveDeadlineSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]v1.Volume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.VolumeMounts != nil {
+		in, out := &in.VolumeMounts, &out.VolumeMounts
+		*out = make([]v1.VolumeMount, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TemplateDefaults != nil {
+		in, out := &in.TemplateDefaults, &out.TemplateDefaults
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkflowDefaultsSpec.
+func (in *WorkflowDefaultsSpec) DeepCopy() *WorkflowDefaultsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkflowDefaultsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkflowEventsSpec) DeepCopyInto(out *WorkflowEventsSpec) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkflowEventsSpec.
+func (in *WorkflowEventsSpec) DeepCopy() *WorkflowEventsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkflowEventsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkflowRestrictionsSpec) DeepCopyInto(out *WorkflowRestrictionsSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkflowRestrictionsSpec.
+func (in *WorkflowRestrictionsSpec) DeepCopy() *WorkflowRestrictionsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkflowRestrictionsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
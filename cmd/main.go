@@ -20,6 +20,7 @@ import (
 	"flag"
 	"github.com/zncdata-labs/argo-workflow-operator/internal/controller"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -53,11 +54,14 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var initialReconcileSpread time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&initialReconcileSpread, "initial-reconcile-spread", 5*time.Second,
+		"Spread the first reconcile of each ArgoWorkFlow after start randomly over this duration.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -89,8 +93,11 @@ func main() {
 	}
 
 	if err = (&controller.ArgoWorkFlowReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("argoworkflow-controller"),
+
+		InitialReconcileSpread: initialReconcileSpread,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ArgoWorkFlow")
 		os.Exit(1)
@@ -18,43 +18,99 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/util/retry"
 
 	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// rolloutRequeueInterval is how often a Deployment rollout is re-checked.
+	rolloutRequeueInterval = 10 * time.Second
+	// defaultReconcileTimeout bounds a reconcile pass when the spec sets no
+	// timeout.
+	defaultReconcileTimeout = 2 * time.Minute
+	// quotaRequeueInterval is how often a reconcile blocked by an exhausted
+	// ResourceQuota is retried.
+	quotaRequeueInterval = time.Minute
+	// requeueJitterFactor is the largest fraction a periodic requeue is
+	// extended by, so objects requeued together drift apart.
+	requeueJitterFactor = 0.2
 )
 
 // ArgoWorkFlowReconciler reconciles a ArgoWorkFlow object
 type ArgoWorkFlowReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Scheme   *runtime.Scheme
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// InitialReconcileSpread delays the first reconcile of each object after
+	// start by a random duration up to this value, so an operator restart
+	// does not reconcile every object at once.
+	InitialReconcileSpread time.Duration
+
+	prober     artifactProber
+	jitter     *jitterSource
+	jitterOnce sync.Once
+	seen       sync.Map
+}
+
+// requeueJitter returns the jitter source of the reconciler, created on first
+// use when SetupWithManager did not set one, as for a reconciler built by
+// hand.
+func (r *ArgoWorkFlowReconciler) requeueJitter() *jitterSource {
+	r.jitterOnce.Do(func() {
+		if r.jitter == nil {
+			r.jitter = newJitterSource(time.Now().UnixNano())
+		}
+	})
+	return r.jitter
 }
 
 // +kubebuilder:rbac:groups=stack.zncdata.net,resources=argoworkflows,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=stack.zncdata.net,resources=argoworkflows/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=stack.zncdata.net,resources=argoworkflows/finalizers,verbs=update
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch;delete
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete;escalate;bind
+// +kubebuilder:rbac:groups=scheduling.k8s.io,resources=priorityclasses,verbs=get;list;watch
 
 // +kubebuilder:rbac:groups="",resources=persistentvolumeclaims;persistentvolumeclaims/finalizers,verbs=get;create;update;delete
 // +kubebuilder:rbac:groups="",resources=pods;pods/exec,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=argoproj.io,resources=workflows;workflows/finalizers;workflowtasksets;workflowtasksets/finalizers;workflowartifactgctasks,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=argoproj.io,resources=workflowtemplates;workflowtemplates/finalizers,verbs=get;list;watch
+// +kubebuilder:rbac:groups=argoproj.io,resources=clusterworkflowtemplates;clusterworkflowtemplates/finalizers,verbs=get;list;watch
+// +kubebuilder:rbac:groups=argoproj.io,resources=eventbus,verbs=get;list;watch
 // +kubebuilder:rbac:groups=argoproj.io,resources=cronworkflows;cronworkflows/finalizers,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 // +kubebuilder:rbac:groups=argoproj.io,resources=workflowtaskresults,verbs=list;watch;deletecollection
 // +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list
 // +kubebuilder:rbac:groups="policy",resources=poddisruptionbudgets,verbs=create;get;delete
-// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=create
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;create;update
 // +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,resourceNames=workflow-controller;workflow-controller-lease,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -66,7 +122,7 @@ type ArgoWorkFlowReconciler struct {
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
-func (r *ArgoWorkFlowReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *ArgoWorkFlowReconciler) Reconcile(ctx context.Context, req ctrl.Request) (result ctrl.Result, err error) {
 
 	r.Log.Info("Reconciling ArgoWorkFlow")
 
@@ -78,69 +134,291 @@ func (r *ArgoWorkFlowReconciler) Reconcile(ctx context.Context, req ctrl.Request
 			return ctrl.Result{}, err
 		}
 		r.Log.Info("ArgoWorkFlow resource not found. Ignoring since object must be deleted")
+		r.seen.Delete(req.NamespacedName)
+		r.prober.forget(req.NamespacedName, nil)
 		return ctrl.Result{}, nil
 	}
 
+	// Bound the whole pass so a stuck call does not hold the worker. The
+	// status is still written with the original context.
+	statusCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, reconcileTimeout(argoWorkflow))
+	defer cancel()
+
+	// Status changes are collected and written once on return, including the
+	// early returns on errors. Once the last finalizer is gone the object is
+	// removed and there is no status left to write.
+	storedStatus := argoWorkflow.Status.DeepCopy()
+	defer func() {
+		if ctx.Err() == context.DeadlineExceeded {
+			argoWorkflow.SetStatusCondition(metav1.Condition{
+				Type:               stackv1alpha1.ConditionTypeReconcile,
+				Status:             metav1.ConditionFalse,
+				Reason:             stackv1alpha1.ConditionReasonReconcileTimedOut,
+				Message:            fmt.Sprintf("Reconcile did not finish within %s", reconcileTimeout(argoWorkflow)),
+				ObservedGeneration: argoWorkflow.GetGeneration(),
+			})
+		} else {
+			apimeta.RemoveStatusCondition(&argoWorkflow.Status.Conditions, stackv1alpha1.ConditionTypeReconcile)
+		}
+
+		if equality.Semantic.DeepEqual(storedStatus, &argoWorkflow.Status) {
+			return
+		}
+		if !argoWorkflow.DeletionTimestamp.IsZero() && len(argoWorkflow.Finalizers) == 0 {
+			return
+		}
+		if updateErr := r.UpdateStatus(statusCtx, argoWorkflow); updateErr != nil && err == nil {
+			result, err = ctrl.Result{}, updateErr
+		}
+	}()
+
+	if !argoWorkflow.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, argoWorkflow)
+	}
+
 	// Get the status condition, if it exists and its generation is not the
-	//same as the ArgoWorkFlow's generation, reset the status conditions
+	//same as the ArgoWorkFlow's generation, reset the status conditions.
+	// Failures of the previous spec do not count towards Degraded either.
 	readCondition := apimeta.FindStatusCondition(argoWorkflow.Status.Conditions, stackv1alpha1.ConditionTypeProgressing)
 	if readCondition == nil || readCondition.ObservedGeneration != argoWorkflow.GetGeneration() {
 		argoWorkflow.InitStatusConditions()
+		argoWorkflow.Status.ConsecutiveFailures = 0
+	}
 
-		if err := r.UpdateStatus(ctx, argoWorkflow); err != nil {
-			return ctrl.Result{}, err
+	r.Log.Info("ArgoWorkFlow found", "Name", argoWorkflow.Name)
+
+	if err := r.reconcilePreDeleteFinalizer(ctx, argoWorkflow); err != nil {
+		r.Log.Error(err, "unable to update pre-delete hook finalizer")
+		return ctrl.Result{}, err
+	}
+
+	if _, seen := r.seen.LoadOrStore(req.NamespacedName, true); !seen && r.InitialReconcileSpread > 0 {
+		delay := r.requeueJitter().Upto(r.InitialReconcileSpread)
+		r.Log.Info("Delaying initial reconcile", "delay", delay)
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
+	resourcesCtx, drift := withDriftTracker(ctx)
+	if err := r.reconcileResources(resourcesCtx, argoWorkflow); err != nil {
+		// Retrying right away cannot succeed until the quota is raised or
+		// freed, check back later instead of failing fast.
+		if isQuotaExceeded(err) {
+			setQuotaExceeded(argoWorkflow, err.Error())
+			_ = r.recordReconcileFailure(argoWorkflow, err)
+			r.Log.Info("Resource quota exceeded, retrying later", "error", err.Error())
+			return ctrl.Result{RequeueAfter: r.requeueJitter().Jitter(quotaRequeueInterval, requeueJitterFactor)}, nil
 		}
+		return ctrl.Result{}, r.recordReconcileFailure(argoWorkflow, err)
 	}
 
-	r.Log.Info("ArgoWorkFlow found", "Name", argoWorkflow.Name)
+	argoWorkflow.Status.ConsecutiveFailures = 0
+	argoWorkflow.Status.DriftDetected = drift.Drifted()
+	if argoWorkflow.Status.DriftDetected {
+		now := metav1.Now()
+		argoWorkflow.Status.LastDriftTime = &now
+	}
+	argoWorkflow.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             stackv1alpha1.ConditionReasonReconcileSucceeded,
+		Message:            "ArgoWorkFlow reconciled successfully",
+		ObservedGeneration: argoWorkflow.GetGeneration(),
+	})
+
+	r.checkArgoCRDs(argoWorkflow)
+	r.checkUnsupportedConfig(argoWorkflow)
+
+	if err := r.checkInstanceID(ctx, argoWorkflow); err != nil {
+		r.Log.Error(err, "unable to check instance ID")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.checkEventBus(ctx, argoWorkflow); err != nil {
+		r.Log.Error(err, "unable to check EventBus")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.updateZoneReadiness(ctx, argoWorkflow); err != nil {
+		r.Log.Error(err, "unable to compute zone readiness")
+		return ctrl.Result{}, err
+	}
+
+	probing := r.updateArtifactReachability(argoWorkflow)
+
+	// Stay Progressing until the Deployment has rolled out the current spec.
+	rolledOut, err := r.checkDeploymentRollout(ctx, argoWorkflow)
+	if err != nil {
+		r.Log.Error(err, "unable to check Deployment rollout")
+		return ctrl.Result{}, err
+	}
+	if !rolledOut {
+		r.Log.Info("Waiting for Deployment rollout")
+		return ctrl.Result{RequeueAfter: r.requeueJitter().Jitter(rolloutRequeueInterval, requeueJitterFactor)}, nil
+	}
+
+	// A finished rollout can still leave pods of the previous config around,
+	// only pods running the current config count towards Available.
+	readyPods, err := r.readyConfigPods(ctx, argoWorkflow)
+	if err != nil {
+		r.Log.Error(err, "unable to count ready controller pods")
+		return ctrl.Result{}, err
+	}
+	replicas, err := r.controllerReplicas(ctx, argoWorkflow)
+	if err != nil {
+		r.Log.Error(err, "unable to get controller replicas")
+		return ctrl.Result{}, err
+	}
+	if readyPods < replicas {
+		argoWorkflow.SetStatusCondition(metav1.Condition{
+			Type:               stackv1alpha1.ConditionTypeAvailable,
+			Status:             metav1.ConditionFalse,
+			Reason:             stackv1alpha1.ConditionReasonConfigRollout,
+			Message:            fmt.Sprintf("%d of %d controller pods are ready with the current config", readyPods, replicas),
+			ObservedGeneration: argoWorkflow.GetGeneration(),
+		})
+		r.Log.Info("Waiting for controller pods with the current config")
+		return ctrl.Result{RequeueAfter: r.requeueJitter().Jitter(rolloutRequeueInterval, requeueJitterFactor)}, nil
+	}
+
+	argoWorkflow.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeAvailable,
+		Status:             metav1.ConditionTrue,
+		Reason:             stackv1alpha1.ConditionReasonRunning,
+		Message:            "ArgoWorkFlow is running",
+		ObservedGeneration: argoWorkflow.GetGeneration(),
+	})
 
+	r.Log.Info("Successfully reconciled ArgoWorkFlow")
+	if probing {
+		return ctrl.Result{RequeueAfter: r.requeueJitter().Jitter(artifactProbeInterval, requeueJitterFactor)}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// reconcileResources reconciles all resources managed for the ArgoWorkFlow.
+func (r *ArgoWorkFlowReconciler) reconcileResources(ctx context.Context, argoWorkflow *stackv1alpha1.ArgoWorkFlow) error {
 	if err := r.reconcileDeployment(ctx, argoWorkflow); err != nil {
 		r.Log.Error(err, "unable to reconcile Deployment")
-		return ctrl.Result{}, err
+		return err
 	}
 
 	if err := r.reconcileService(ctx, argoWorkflow); err != nil {
 		r.Log.Error(err, "unable to reconcile Service")
-		return ctrl.Result{}, err
+		return err
+	}
+
+	if err := r.reconcileMetricsService(ctx, argoWorkflow); err != nil {
+		r.Log.Error(err, "unable to reconcile metrics Service")
+		return err
 	}
 
 	if err := r.reconcileServiceAccount(ctx, argoWorkflow); err != nil {
 		r.Log.Error(err, "unable to reconcile ServiceAccount")
-		return ctrl.Result{}, err
+		return err
+	}
+
+	if err := r.reconcileLease(ctx, argoWorkflow); err != nil {
+		r.Log.Error(err, "unable to reconcile Lease")
+		return err
 	}
 
 	if err := r.reconcileClusterRoleBinding(ctx, argoWorkflow); err != nil {
 		r.Log.Error(err, "unable to reconcile ClusterRoleBinding")
-		return ctrl.Result{}, err
+		return err
+	}
+
+	if err := r.reconcileWorkflowRole(ctx, argoWorkflow); err != nil {
+		r.Log.Error(err, "unable to reconcile workflow Role")
+		return err
 	}
 
 	if err := r.reconcileConfigMap(ctx, argoWorkflow); err != nil {
 		r.Log.Error(err, "unable to reconcile ConfigMap")
+		return err
+	}
+
+	if err := r.reconcileArtifactRepositories(ctx, argoWorkflow); err != nil {
+		r.Log.Error(err, "unable to reconcile artifact repositories ConfigMap")
+		return err
+	}
+
+	return nil
+}
+
+func reconcileTimeout(argoWorkflow *stackv1alpha1.ArgoWorkFlow) time.Duration {
+	if timeout := argoWorkflow.Spec.ReconcileTimeout; timeout != nil && timeout.Duration > 0 {
+		return timeout.Duration
+	}
+	return defaultReconcileTimeout
+}
+
+// finalize runs the cleanup of a deleted ArgoWorkFlow. The Terminating
+// condition shows what the deletion is waiting for or why the cleanup failed,
+// and is removed once the cleanup is done.
+func (r *ArgoWorkFlowReconciler) finalize(ctx context.Context, argoWorkflow *stackv1alpha1.ArgoWorkFlow) (ctrl.Result, error) {
+	proceed, err := r.runPreDeleteHook(ctx, argoWorkflow)
+	if err != nil {
+		r.Log.Error(err, "unable to run pre-delete hook")
+		setTerminating(argoWorkflow, stackv1alpha1.ConditionReasonCleanupFailed, fmt.Sprintf("Pre-delete hook failed: %s", err))
 		return ctrl.Result{}, err
 	}
+	if !proceed {
+		setTerminating(argoWorkflow, stackv1alpha1.ConditionReasonCleanupInProgress, "Waiting for the pre-delete hook")
+		return ctrl.Result{RequeueAfter: preDeleteHookInterval}, nil
+	}
+	if err := r.deleteClusterRole(ctx, argoWorkflow); err != nil {
+		r.Log.Error(err, "unable to clean up ClusterRole")
+		setTerminating(argoWorkflow, stackv1alpha1.ConditionReasonCleanupFailed, fmt.Sprintf("ClusterRole cleanup failed: %s", err))
+		return ctrl.Result{}, err
+	}
+	apimeta.RemoveStatusCondition(&argoWorkflow.Status.Conditions, stackv1alpha1.ConditionTypeTerminating)
+	return ctrl.Result{}, nil
+}
 
+func setTerminating(argoWorkflow *stackv1alpha1.ArgoWorkFlow, reason, message string) {
 	argoWorkflow.SetStatusCondition(metav1.Condition{
-		Type:               stackv1alpha1.ConditionTypeAvailable,
+		Type:               stackv1alpha1.ConditionTypeTerminating,
 		Status:             metav1.ConditionTrue,
-		Reason:             stackv1alpha1.ConditionReasonRunning,
-		Message:            "ArgoWorkFlow is running",
+		Reason:             reason,
+		Message:            message,
 		ObservedGeneration: argoWorkflow.GetGeneration(),
 	})
+}
 
-	if err := r.UpdateStatus(ctx, argoWorkflow); err != nil {
-		return ctrl.Result{}, err
+// recordReconcileFailure counts a failed reconcile in the status and sets the
+// Degraded condition once Spec.DegradedThreshold consecutive failures are
+// reached. It returns the original reconcile error.
+func (r *ArgoWorkFlowReconciler) recordReconcileFailure(argoWorkflow *stackv1alpha1.ArgoWorkFlow, reconcileErr error) error {
+	argoWorkflow.Status.ConsecutiveFailures++
+	if argoWorkflow.Status.ConsecutiveFailures >= argoWorkflow.Spec.DegradedThreshold {
+		argoWorkflow.SetStatusCondition(metav1.Condition{
+			Type:               stackv1alpha1.ConditionTypeDegraded,
+			Status:             metav1.ConditionTrue,
+			Reason:             stackv1alpha1.ConditionReasonReconcileFailed,
+			Message:            reconcileErr.Error(),
+			ObservedGeneration: argoWorkflow.GetGeneration(),
+		})
 	}
-
-	r.Log.Info("Successfully reconciled ArgoWorkFlow")
-	return ctrl.Result{}, nil
+	return reconcileErr
 }
 
-// UpdateStatus updates the status of the ArgoWorkFlow resource
+// UpdateStatus updates the status of the ArgoWorkFlow resource. On conflicts
+// the latest object is fetched and the status is applied to it again.
 // https://stackoverflow.com/questions/76388004/k8s-controller-update-status-and-condition
 func (r *ArgoWorkFlowReconciler) UpdateStatus(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	status := instance.Status.DeepCopy()
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		return r.Status().Update(ctx, instance)
-		//return r.Status().Patch(ctx, instance, client.MergeFrom(instance))
+		latest := &stackv1alpha1.ArgoWorkFlow{}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(instance), latest); err != nil {
+			return err
+		}
+		latest.Status = *status
+		if err := r.Status().Update(ctx, latest); err != nil {
+			return err
+		}
+		instance.SetResourceVersion(latest.GetResourceVersion())
+		return nil
 	})
 
 	if retryErr != nil {
@@ -152,9 +430,41 @@ func (r *ArgoWorkFlowReconciler) UpdateStatus(ctx context.Context, instance *sta
 	return nil
 }
 
+// referencingArgoWorkFlows maps a ConfigMap to the ArgoWorkFlows of its
+// namespace that read it as existing or base controller config. The operator
+// does not own these ConfigMaps, without the mapping their changes would only
+// roll the controller pods on the next unrelated reconcile.
+func (r *ArgoWorkFlowReconciler) referencingArgoWorkFlows(ctx context.Context, obj client.Object) []reconcile.Request {
+	instances := &stackv1alpha1.ArgoWorkFlowList{}
+	if err := r.List(ctx, instances, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list ArgoWorkFlows for ConfigMap", "ConfigMap", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range instances.Items {
+		controllerConfig := instances.Items[i].Spec.ControllerConfig
+		if controllerConfig == nil {
+			continue
+		}
+		if controllerConfig.ExistingConfigMap == obj.GetName() || controllerConfig.BaseConfigMap == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&instances.Items[i])})
+		}
+	}
+	return requests
+}
+
 // SetupWithManager sets up the controller with the Manager.
+// Status-only updates of the ArgoWorkFlow, such as the ones written by
+// UpdateStatus, are filtered out; events of owned objects are not. Changes of
+// ConfigMaps referenced as existing or base config trigger a reconcile too.
 func (r *ArgoWorkFlowReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&stackv1alpha1.ArgoWorkFlow{}).
+		For(&stackv1alpha1.ArgoWorkFlow{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.LabelChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
+		Owns(&appsv1.Deployment{}).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.referencingArgoWorkFlows)).
 		Complete(r)
 }
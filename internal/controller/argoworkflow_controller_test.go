@@ -0,0 +1,681 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := stackv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+// newTestArgoWorkFlow returns an ArgoWorkFlow with the defaults the API server
+// would apply for the fields the reconciler reads.
+func newTestArgoWorkFlow(namespace, name string) *stackv1alpha1.ArgoWorkFlow {
+	return &stackv1alpha1.ArgoWorkFlow{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  namespace,
+			Generation: 1,
+			UID:        types.UID(namespace + "-" + name),
+			Labels:     map[string]string{"app": name},
+		},
+		Spec: stackv1alpha1.ArgoWorkFlowSpec{
+			Image: &stackv1alpha1.ImageSpec{
+				Repository: "quay.io/argoproj/workflow-controller",
+				Tag:        "v3.5.2",
+				PullPolicy: corev1.PullIfNotPresent,
+			},
+			Service:           &stackv1alpha1.ServiceSpec{Port: 2746, Type: corev1.ServiceTypeClusterIP},
+			Replicas:          1,
+			DegradedThreshold: 3,
+		},
+	}
+}
+
+// newTestClientBuilder returns a fake client builder holding objs.
+func newTestClientBuilder(t *testing.T, objs ...client.Object) *fake.ClientBuilder {
+	t.Helper()
+	return fake.NewClientBuilder().
+		WithScheme(newTestScheme(t)).
+		WithObjects(objs...).
+		WithStatusSubresource(&stackv1alpha1.ArgoWorkFlow{}, &appsv1.Deployment{})
+}
+
+// newTestReconciler returns a reconciler backed by a fake client holding objs.
+func newTestReconciler(t *testing.T, objs ...client.Object) *ArgoWorkFlowReconciler {
+	t.Helper()
+	return newTestReconcilerFor(newTestClientBuilder(t, objs...).Build())
+}
+
+func newTestReconcilerFor(c client.Client) *ArgoWorkFlowReconciler {
+	return &ArgoWorkFlowReconciler{
+		Client:   c,
+		Scheme:   c.Scheme(),
+		Log:      logr.Discard(),
+		Recorder: record.NewFakeRecorder(100),
+		jitter:   newJitterSource(1),
+	}
+}
+
+// recordedEvent drains the events recorded by r and reports whether one of
+// them has the reason.
+func recordedEvent(r *ArgoWorkFlowReconciler, reason string) bool {
+	events := r.Recorder.(*record.FakeRecorder).Events
+	found := false
+	for len(events) > 0 {
+		if strings.Contains(<-events, " "+reason+" ") {
+			found = true
+		}
+	}
+	return found
+}
+
+func TestReconcileWritesStatusOnce(t *testing.T) {
+	tests := []struct {
+		name       string
+		mutate     func(instance *stackv1alpha1.ArgoWorkFlow)
+		failCreate bool
+		wantErr    bool
+		// Once the managed objects exist a pass only writes the status when
+		// it changed, a failed pass counts the failure again.
+		wantSteadyWrites int
+	}{
+		{name: "successful pass"},
+		{name: "failed pass", failCreate: true, wantErr: true, wantSteadyWrites: 1},
+		{
+			name: "pass with cluster RBAC and a finalizer update",
+			mutate: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.RBAC = &stackv1alpha1.RBACSpec{CreateClusterRole: true}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			if tt.mutate != nil {
+				tt.mutate(instance)
+			}
+			statusWrites := 0
+			builder := newTestClientBuilder(t, instance).WithInterceptorFuncs(interceptor.Funcs{
+				Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+					if _, ok := obj.(*appsv1.Deployment); ok && tt.failCreate {
+						return fmt.Errorf("create failed")
+					}
+					return c.Create(ctx, obj, opts...)
+				},
+				SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+					statusWrites++
+					return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+				},
+			})
+			r := newTestReconcilerFor(builder.Build())
+
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+			if _, err := r.Reconcile(ctx, req); (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if statusWrites != 1 {
+				t.Errorf("first reconcile wrote the status %d times, want 1", statusWrites)
+			}
+
+			// The second pass clears the drift reported for creating the
+			// objects, the third one runs against the steady state.
+			for pass := 2; pass <= 3; pass++ {
+				statusWrites = 0
+				if _, err := r.Reconcile(ctx, req); (err != nil) != tt.wantErr {
+					t.Fatalf("Reconcile() pass %d error = %v, wantErr %v", pass, err, tt.wantErr)
+				}
+				if statusWrites > 1 {
+					t.Errorf("reconcile pass %d wrote the status %d times", pass, statusWrites)
+				}
+			}
+			if statusWrites != tt.wantSteadyWrites {
+				t.Errorf("steady state reconcile wrote the status %d times, want %d", statusWrites, tt.wantSteadyWrites)
+			}
+
+			stored := &stackv1alpha1.ArgoWorkFlow{}
+			if err := r.Get(ctx, req.NamespacedName, stored); err != nil {
+				t.Fatal(err)
+			}
+			if apimeta.FindStatusCondition(stored.Status.Conditions, stackv1alpha1.ConditionTypeProgressing) == nil {
+				t.Errorf("status was written without the Progressing condition: %v", stored.Status.Conditions)
+			}
+		})
+	}
+}
+
+func TestReconcileReportsDrift(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	r := newTestReconciler(t, instance)
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+
+	reconcile := func() *stackv1alpha1.ArgoWorkFlow {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, req); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		stored := &stackv1alpha1.ArgoWorkFlow{}
+		if err := r.Get(ctx, req.NamespacedName, stored); err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+
+	if stored := reconcile(); !stored.Status.DriftDetected {
+		t.Errorf("first reconcile created the managed objects, DriftDetected = false")
+	}
+	if stored := reconcile(); stored.Status.DriftDetected {
+		t.Errorf("no-op reconcile left DriftDetected set")
+	}
+
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	deployment.Spec.Template.Spec.Containers[0].Image = "example.com/patched:latest"
+	if err := r.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	stored := reconcile()
+	if !stored.Status.DriftDetected || stored.Status.LastDriftTime == nil {
+		t.Errorf("mutated Deployment: DriftDetected = %v, LastDriftTime = %v", stored.Status.DriftDetected, stored.Status.LastDriftTime)
+	}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image == "example.com/patched:latest" {
+		t.Errorf("Deployment image %q was not reverted", image)
+	}
+}
+
+func TestReferencingArgoWorkFlows(t *testing.T) {
+	withConfig := func(namespace, name string, config *stackv1alpha1.ControllerConfigSpec) *stackv1alpha1.ArgoWorkFlow {
+		instance := newTestArgoWorkFlow(namespace, name)
+		instance.Spec.ControllerConfig = config
+		return instance
+	}
+	r := newTestReconciler(t,
+		withConfig("ns", "existing", &stackv1alpha1.ControllerConfigSpec{ExistingConfigMap: "shared"}),
+		withConfig("ns", "base", &stackv1alpha1.ControllerConfigSpec{BaseConfigMap: "shared"}),
+		withConfig("ns", "generated", nil),
+		withConfig("other", "existing", &stackv1alpha1.ControllerConfigSpec{ExistingConfigMap: "shared"}),
+	)
+	tests := []struct {
+		name      string
+		configMap string
+		want      []string
+	}{
+		{name: "referenced as existing and base config", configMap: "shared", want: []string{"ns/base", "ns/existing"}},
+		{name: "unreferenced", configMap: "unrelated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: tt.configMap}}
+			var got []string
+			for _, req := range r.referencingArgoWorkFlows(context.Background(), configMap) {
+				got = append(got, req.String())
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("referencingArgoWorkFlows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestControllerConfigHashExistingConfigMap(t *testing.T) {
+	ctx := context.Background()
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "shared"},
+		Data:       map[string]string{"config": "parallelism: 10"},
+	}
+	instance := newTestArgoWorkFlow("ns", "argo")
+	instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{ExistingConfigMap: "shared"}
+	r := newTestReconciler(t, configMap)
+
+	before, err := r.controllerConfigHash(ctx, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMap.Data["config"] = "parallelism: 20"
+	if err := r.Update(ctx, configMap); err != nil {
+		t.Fatal(err)
+	}
+	after, err := r.controllerConfigHash(ctx, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Errorf("config hash %s did not change with the existing ConfigMap", before)
+	}
+}
+
+func TestReconcileSpreadsInitialReconcile(t *testing.T) {
+	tests := []struct {
+		name      string
+		spread    time.Duration
+		noJitter  bool
+		wantDelay bool
+	}{
+		{name: "spread", spread: time.Minute, wantDelay: true},
+		{name: "no spread"},
+		{name: "reconciler without a jitter source", spread: time.Minute, noJitter: true, wantDelay: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			r := newTestReconciler(t, instance)
+			r.InitialReconcileSpread = tt.spread
+			if tt.noJitter {
+				// As for a reconciler not set up through SetupWithManager.
+				r.jitter = nil
+			}
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+
+			result, err := r.Reconcile(ctx, req)
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			created := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo"}, &appsv1.Deployment{}) == nil
+			if created == tt.wantDelay {
+				t.Errorf("first reconcile created the Deployment = %v, want %v", created, !tt.wantDelay)
+			}
+			if tt.wantDelay && (result.RequeueAfter <= 0 || result.RequeueAfter >= tt.spread) {
+				t.Errorf("RequeueAfter = %v, want a delay below %v", result.RequeueAfter, tt.spread)
+			}
+			// The Deployment never rolls out in the fake client, so the
+			// periodic rollout check is requeued with jitter.
+			maxRequeue := rolloutRequeueInterval + time.Duration(requeueJitterFactor*float64(rolloutRequeueInterval))
+			if !tt.wantDelay && (result.RequeueAfter < rolloutRequeueInterval || result.RequeueAfter > maxRequeue) {
+				t.Errorf("RequeueAfter = %v, want it in [%v, %v]", result.RequeueAfter, rolloutRequeueInterval, maxRequeue)
+			}
+
+			// Only the first reconcile of an instance is delayed.
+			if _, err := r.Reconcile(ctx, req); err != nil {
+				t.Fatalf("second Reconcile() error = %v", err)
+			}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo"}, &appsv1.Deployment{}); err != nil {
+				t.Errorf("second reconcile did not create the Deployment: %v", err)
+			}
+		})
+	}
+}
+
+// rollOutDeployment marks the controller Deployment as rolled out, which the
+// fake client never does on its own.
+func rollOutDeployment(t *testing.T, c client.Client, key client.ObjectKey) {
+	t.Helper()
+	deployment := &appsv1.Deployment{}
+	if err := c.Get(context.Background(), key, deployment); err != nil {
+		t.Fatal(err)
+	}
+	deployment.Status.ObservedGeneration = deployment.Generation
+	deployment.Status.UpdatedReplicas = *deployment.Spec.Replicas
+	if err := c.Status().Update(context.Background(), deployment); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileAvailableWithCurrentConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		podHash    func(stored *stackv1alpha1.ArgoWorkFlow) string
+		wantStatus metav1.ConditionStatus
+		wantReason string
+	}{
+		{
+			name:       "pod of the previous config",
+			podHash:    func(*stackv1alpha1.ArgoWorkFlow) string { return "previous" },
+			wantStatus: metav1.ConditionFalse,
+			wantReason: stackv1alpha1.ConditionReasonConfigRollout,
+		},
+		{
+			name:       "pod of the current config",
+			podHash:    func(stored *stackv1alpha1.ArgoWorkFlow) string { return stored.Status.ConfigHash },
+			wantStatus: metav1.ConditionTrue,
+			wantReason: stackv1alpha1.ConditionReasonRunning,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			r := newTestReconciler(t, instance)
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+			if _, err := r.Reconcile(ctx, req); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			rollOutDeployment(t, r.Client, req.NamespacedName)
+			stored := &stackv1alpha1.ArgoWorkFlow{}
+			if err := r.Get(ctx, req.NamespacedName, stored); err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "argo-pod",
+					Namespace:   "ns",
+					Labels:      map[string]string{"app": "argo"},
+					Annotations: map[string]string{configHashAnnotation: tt.podHash(stored)},
+				},
+				Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+			}
+			if err := r.Create(ctx, pod); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := r.Reconcile(ctx, req); err != nil {
+				t.Fatalf("second Reconcile() error = %v", err)
+			}
+			if err := r.Get(ctx, req.NamespacedName, stored); err != nil {
+				t.Fatal(err)
+			}
+			condition := apimeta.FindStatusCondition(stored.Status.Conditions, stackv1alpha1.ConditionTypeAvailable)
+			if condition == nil || condition.Status != tt.wantStatus || condition.Reason != tt.wantReason {
+				t.Errorf("%s condition = %v, want %s/%s", stackv1alpha1.ConditionTypeAvailable, condition, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestUpdateStatusRetriesConflicts(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflicts int
+		wantErr   bool
+	}{
+		{name: "no conflict"},
+		{name: "one conflict", conflicts: 1},
+		{name: "several conflicts", conflicts: 3},
+		{name: "conflicts beyond the retries", conflicts: 100, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			attempts := 0
+			builder := newTestClientBuilder(t, instance).WithInterceptorFuncs(interceptor.Funcs{
+				SubResourceUpdate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+					attempts++
+					if attempts <= tt.conflicts {
+						return errors.NewConflict(stackv1alpha1.GroupVersion.WithResource("argoworkflows").GroupResource(), obj.GetName(), fmt.Errorf("object was modified"))
+					}
+					return c.SubResource(subResourceName).Update(ctx, obj, opts...)
+				},
+			})
+			r := newTestReconcilerFor(builder.Build())
+
+			// The instance read at the start of the reconcile went stale, the
+			// labels were changed in the meantime.
+			stale := &stackv1alpha1.ArgoWorkFlow{}
+			if err := r.Get(ctx, client.ObjectKeyFromObject(instance), stale); err != nil {
+				t.Fatal(err)
+			}
+			latest := stale.DeepCopy()
+			latest.Labels["team"] = "a"
+			if err := r.Update(ctx, latest); err != nil {
+				t.Fatal(err)
+			}
+			stale.Status.ConfigHash = "abc"
+
+			err := r.UpdateStatus(ctx, stale)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.IsConflict(err) {
+					t.Errorf("UpdateStatus() error = %v, want the conflict", err)
+				}
+				return
+			}
+			if attempts != tt.conflicts+1 {
+				t.Errorf("status written %d times, want %d", attempts, tt.conflicts+1)
+			}
+			stored := &stackv1alpha1.ArgoWorkFlow{}
+			if err := r.Get(ctx, client.ObjectKeyFromObject(instance), stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status.ConfigHash != "abc" {
+				t.Errorf("Status.ConfigHash = %q, want the status of the stale instance", stored.Status.ConfigHash)
+			}
+			if stored.Labels["team"] != "a" {
+				t.Errorf("labels = %v, the concurrent update was lost", stored.Labels)
+			}
+			if stale.ResourceVersion != stored.ResourceVersion {
+				t.Errorf("ResourceVersion = %q, want the stored %q", stale.ResourceVersion, stored.ResourceVersion)
+			}
+		})
+	}
+}
+
+func TestReconcileQuotaExceeded(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	quotaErr := errors.NewForbidden(appsv1.Resource("deployments"), "argo", fmt.Errorf("exceeded quota: compute, requested: pods=1"))
+	builder := newTestClientBuilder(t, instance).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if _, ok := obj.(*appsv1.Deployment); ok {
+				return quotaErr
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	})
+	r := newTestReconcilerFor(builder.Build())
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+
+	// Failing fast cannot succeed until the quota is raised, the reconcile
+	// is retried later instead.
+	result, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v, want the quota error to be requeued", err)
+	}
+	maxRequeue := quotaRequeueInterval + time.Duration(requeueJitterFactor*float64(quotaRequeueInterval))
+	if result.RequeueAfter < quotaRequeueInterval || result.RequeueAfter > maxRequeue {
+		t.Errorf("RequeueAfter = %v, want it in [%v, %v]", result.RequeueAfter, quotaRequeueInterval, maxRequeue)
+	}
+
+	stored := &stackv1alpha1.ArgoWorkFlow{}
+	if err := r.Get(ctx, req.NamespacedName, stored); err != nil {
+		t.Fatal(err)
+	}
+	condition := apimeta.FindStatusCondition(stored.Status.Conditions, stackv1alpha1.ConditionTypeQuota)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != stackv1alpha1.ConditionReasonQuotaExceeded {
+		t.Errorf("%s condition = %v, want True/%s", stackv1alpha1.ConditionTypeQuota, condition, stackv1alpha1.ConditionReasonQuotaExceeded)
+	}
+	if stored.Status.ConsecutiveFailures != 1 {
+		t.Errorf("ConsecutiveFailures = %d, want the failure counted", stored.Status.ConsecutiveFailures)
+	}
+}
+
+func TestReconcileTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      *metav1.Duration
+		slow         bool
+		want         time.Duration
+		wantTimedOut bool
+		wantErr      bool
+	}{
+		{name: "default", want: defaultReconcileTimeout},
+		{name: "zero falls back to the default", timeout: &metav1.Duration{}, want: defaultReconcileTimeout},
+		{name: "pass within the timeout", timeout: &metav1.Duration{Duration: time.Minute}, want: time.Minute},
+		{name: "stuck pass", timeout: &metav1.Duration{Duration: 50 * time.Millisecond}, slow: true, want: 50 * time.Millisecond, wantTimedOut: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.ReconcileTimeout = tt.timeout
+			if got := reconcileTimeout(instance); got != tt.want {
+				t.Errorf("reconcileTimeout() = %v, want %v", got, tt.want)
+			}
+
+			slow := tt.slow
+			builder := newTestClientBuilder(t, instance).WithInterceptorFuncs(interceptor.Funcs{
+				Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+					if _, ok := obj.(*appsv1.Deployment); ok && slow {
+						// A call that only returns once the pass is cancelled.
+						<-ctx.Done()
+						return ctx.Err()
+					}
+					return c.Create(ctx, obj, opts...)
+				},
+			})
+			r := newTestReconcilerFor(builder.Build())
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+
+			if _, err := r.Reconcile(ctx, req); (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			stored := &stackv1alpha1.ArgoWorkFlow{}
+			if err := r.Get(ctx, req.NamespacedName, stored); err != nil {
+				t.Fatal(err)
+			}
+			condition := apimeta.FindStatusCondition(stored.Status.Conditions, stackv1alpha1.ConditionTypeReconcile)
+			if !tt.wantTimedOut {
+				if condition != nil {
+					t.Errorf("unexpected %s condition: %v", stackv1alpha1.ConditionTypeReconcile, condition)
+				}
+				return
+			}
+			if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != stackv1alpha1.ConditionReasonReconcileTimedOut {
+				t.Errorf("%s condition = %v, want False/%s", stackv1alpha1.ConditionTypeReconcile, condition, stackv1alpha1.ConditionReasonReconcileTimedOut)
+			}
+
+			// The next pass that finishes in time clears the condition.
+			slow = false
+			if _, err := r.Reconcile(ctx, req); err != nil {
+				t.Fatalf("second Reconcile() error = %v", err)
+			}
+			if err := r.Get(ctx, req.NamespacedName, stored); err != nil {
+				t.Fatal(err)
+			}
+			if condition := apimeta.FindStatusCondition(stored.Status.Conditions, stackv1alpha1.ConditionTypeReconcile); condition != nil {
+				t.Errorf("%s condition left after a pass within the timeout: %v", stackv1alpha1.ConditionTypeReconcile, condition)
+			}
+		})
+	}
+}
+
+func TestReconcileCleanupFailure(t *testing.T) {
+	ctx := context.Background()
+	now := metav1.Now()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	instance.DeletionTimestamp = &now
+	instance.Finalizers = []string{clusterRBACFinalizer}
+	clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: clusterRBACName(instance)}}
+	failDelete := true
+	builder := newTestClientBuilder(t, instance, clusterRole).WithInterceptorFuncs(interceptor.Funcs{
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if _, ok := obj.(*rbacv1.ClusterRole); ok && failDelete {
+				return fmt.Errorf("connection refused")
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+	})
+	r := newTestReconcilerFor(builder.Build())
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+
+	if _, err := r.Reconcile(ctx, req); err == nil {
+		t.Fatal("Reconcile() error = nil, want the cleanup error")
+	}
+	stored := &stackv1alpha1.ArgoWorkFlow{}
+	if err := r.Get(ctx, req.NamespacedName, stored); err != nil {
+		t.Fatalf("ArgoWorkFlow removed after a failed cleanup: %v", err)
+	}
+	if !reflect.DeepEqual(stored.Finalizers, []string{clusterRBACFinalizer}) {
+		t.Errorf("Finalizers = %v, want %s kept", stored.Finalizers, clusterRBACFinalizer)
+	}
+	condition := apimeta.FindStatusCondition(stored.Status.Conditions, stackv1alpha1.ConditionTypeTerminating)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != stackv1alpha1.ConditionReasonCleanupFailed {
+		t.Fatalf("%s condition = %v, want True/%s", stackv1alpha1.ConditionTypeTerminating, condition, stackv1alpha1.ConditionReasonCleanupFailed)
+	}
+	if !strings.Contains(condition.Message, "connection refused") {
+		t.Errorf("condition message = %q, want the cleanup error", condition.Message)
+	}
+
+	// Once the cleanup goes through the object is released.
+	failDelete = false
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := r.Get(ctx, req.NamespacedName, stored); !errors.IsNotFound(err) {
+		t.Errorf("Get() error = %v, want the ArgoWorkFlow removed", err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(clusterRole), &rbacv1.ClusterRole{}); !errors.IsNotFound(err) {
+		t.Errorf("Get(ClusterRole) error = %v, want it deleted", err)
+	}
+}
+
+func TestReconcileResetsFailuresOnGenerationChange(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	instance.Generation = 2
+	instance.Status.ConsecutiveFailures = 2
+	instance.Status.Conditions = []metav1.Condition{{
+		Type:               stackv1alpha1.ConditionTypeProgressing,
+		Status:             metav1.ConditionTrue,
+		Reason:             stackv1alpha1.ConditionReasonRunning,
+		ObservedGeneration: 1,
+		LastTransitionTime: metav1.Now(),
+	}}
+	builder := newTestClientBuilder(t, instance).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if _, ok := obj.(*appsv1.Deployment); ok {
+				return fmt.Errorf("admission webhook denied the request")
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	})
+	r := newTestReconcilerFor(builder.Build())
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+
+	if _, err := r.Reconcile(ctx, req); err == nil {
+		t.Fatal("Reconcile() error = nil, want the Deployment error")
+	}
+	stored := &stackv1alpha1.ArgoWorkFlow{}
+	if err := r.Get(ctx, req.NamespacedName, stored); err != nil {
+		t.Fatal(err)
+	}
+	// Two failures of generation 1 and one of generation 2 would reach the
+	// threshold of 3, only the new one counts.
+	if stored.Status.ConsecutiveFailures != 1 {
+		t.Errorf("ConsecutiveFailures = %d, want 1", stored.Status.ConsecutiveFailures)
+	}
+	if condition := apimeta.FindStatusCondition(stored.Status.Conditions, stackv1alpha1.ConditionTypeDegraded); condition != nil && condition.Status == metav1.ConditionTrue {
+		t.Errorf("Degraded = %+v, want it unset after the generation change", condition)
+	}
+}
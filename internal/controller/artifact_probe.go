@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// artifactProbeInterval is how often a reachability probe is repeated.
+const artifactProbeInterval = time.Minute
+
+type probeResult struct {
+	url       string
+	reachable bool
+	message   string
+}
+
+// probeKey identifies the probe of one artifact repository of an ArgoWorkFlow.
+type probeKey struct {
+	instance   types.NamespacedName
+	repository string
+}
+
+// probeTarget is an artifact repository with its health check enabled.
+type probeTarget struct {
+	repository string
+	url        string
+	caBundle   string
+	timeout    time.Duration
+}
+
+// artifactProber probes artifact repository endpoints in the background, so
+// a slow or unreachable endpoint never holds up a reconcile. Only the latest
+// result per repository is kept.
+type artifactProber struct {
+	mu       sync.Mutex
+	results  map[probeKey]probeResult
+	inflight map[probeKey]bool
+}
+
+// result returns the latest probe result for url, if any.
+func (p *artifactProber) result(key probeKey, url string) (probeResult, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result, ok := p.results[key]
+	if !ok || result.url != url {
+		return probeResult{}, false
+	}
+	return result, true
+}
+
+// start probes the target in the background unless a probe for key is
+// running.
+func (p *artifactProber) start(key probeKey, target probeTarget) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inflight == nil {
+		p.inflight = map[probeKey]bool{}
+		p.results = map[probeKey]probeResult{}
+	}
+	if p.inflight[key] {
+		return
+	}
+	p.inflight[key] = true
+
+	go func() {
+		result := probeURL(target.url, target.caBundle, target.timeout)
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.results[key] = result
+		delete(p.inflight, key)
+	}()
+}
+
+// forget drops the results kept for the repositories of instance that are not
+// in keep.
+func (p *artifactProber) forget(instance types.NamespacedName, keep map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key := range p.results {
+		if key.instance == instance && !keep[key.repository] {
+			delete(p.results, key)
+		}
+	}
+}
+
+// probeURL sends a HEAD request for the bucket. Any HTTP response, including
+// 403 for a bucket that needs credentials, means the endpoint is reachable.
+// Redirects are not followed, the redirect itself is the endpoint's answer.
+// Server certificates are verified against the system roots and caBundle.
+func probeURL(url, caBundle string, timeout time.Duration) probeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	httpClient, err := newProbeClient(caBundle)
+	if err != nil {
+		return probeResult{url: url, message: err.Error()}
+	}
+	defer httpClient.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return probeResult{url: url, message: err.Error()}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return probeResult{url: url, message: err.Error()}
+	}
+	resp.Body.Close()
+	return probeResult{url: url, reachable: true, message: fmt.Sprintf("%s answered with %s", url, resp.Status)}
+}
+
+// newProbeClient returns the HTTP client of a probe. It is not shared with
+// anything else in the operator, so the CA bundle of one repository is only
+// trusted for that repository.
+func newProbeClient(caBundle string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caBundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(caBundle)) {
+			return nil, fmt.Errorf("health check caBundle contains no PEM certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// artifactProbeTargets returns the bucket URLs of the artifact repositories
+// with their health check enabled, ordered by repository key.
+func artifactProbeTargets(instance *stackv1alpha1.ArgoWorkFlow) []probeTarget {
+	var targets []probeTarget
+	for key, repo := range instance.Spec.ArtifactRepositories {
+		if repo.S3 == nil || repo.HealthCheck == nil || !repo.HealthCheck.Enabled {
+			continue
+		}
+		scheme := "https"
+		if repo.S3.Insecure != nil && *repo.S3.Insecure {
+			scheme = "http"
+		}
+		timeout := 5 * time.Second
+		if repo.HealthCheck.TimeoutSeconds > 0 {
+			timeout = time.Duration(repo.HealthCheck.TimeoutSeconds) * time.Second
+		}
+		targets = append(targets, probeTarget{
+			repository: key,
+			url:        fmt.Sprintf("%s://%s/%s", scheme, repo.S3.Endpoint, repo.S3.Bucket),
+			caBundle:   repo.HealthCheck.CABundle,
+			timeout:    timeout,
+		})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].repository < targets[j].repository })
+	return targets
+}
+
+// updateArtifactReachability sets the ArtifactRepoReachable condition from the
+// latest probe results and starts the next probes. The condition is False
+// when any probed repository is unreachable. It reports whether a health
+// check is enabled, so the caller can requeue for the next probe.
+func (r *ArgoWorkFlowReconciler) updateArtifactReachability(instance *stackv1alpha1.ArgoWorkFlow) bool {
+	instanceKey := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+	targets := artifactProbeTargets(instance)
+	keep := map[string]bool{}
+	for _, target := range targets {
+		keep[target.repository] = true
+	}
+	r.prober.forget(instanceKey, keep)
+	if len(targets) == 0 {
+		apimeta.RemoveStatusCondition(&instance.Status.Conditions, stackv1alpha1.ConditionTypeReachable)
+		return false
+	}
+
+	var reachable, unreachable []string
+	for _, target := range targets {
+		key := probeKey{instance: instanceKey, repository: target.repository}
+		if result, ok := r.prober.result(key, target.url); ok {
+			message := fmt.Sprintf("%s: %s", target.repository, result.message)
+			if result.reachable {
+				reachable = append(reachable, message)
+			} else {
+				unreachable = append(unreachable, message)
+			}
+		}
+		r.prober.start(key, target)
+	}
+
+	condition := metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeReachable,
+		Status:             metav1.ConditionTrue,
+		Reason:             stackv1alpha1.ConditionReasonProbeSucceeded,
+		Message:            strings.Join(reachable, "; "),
+		ObservedGeneration: instance.GetGeneration(),
+	}
+	if len(unreachable) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = stackv1alpha1.ConditionReasonProbeFailed
+		condition.Message = strings.Join(unreachable, "; ")
+	}
+	if len(reachable) > 0 || len(unreachable) > 0 {
+		instance.SetStatusCondition(condition)
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+)
+
+func TestUpdateArtifactReachability(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	reachable := strings.TrimPrefix(server.URL, "http://")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := listener.Addr().String()
+	listener.Close()
+
+	repo := func(endpoint string, healthCheck bool) stackv1alpha1.ArtifactRepositorySpec {
+		return stackv1alpha1.ArtifactRepositorySpec{
+			S3:          &stackv1alpha1.S3ArtifactRepositorySpec{Endpoint: endpoint, Bucket: "artifacts", Insecure: pointer.Bool(true)},
+			HealthCheck: &stackv1alpha1.ArtifactHealthCheckSpec{Enabled: healthCheck, TimeoutSeconds: 1},
+		}
+	}
+	tests := []struct {
+		name          string
+		repositories  map[string]stackv1alpha1.ArtifactRepositorySpec
+		wantProbing   bool
+		wantStatus    metav1.ConditionStatus
+		wantInMessage string
+	}{
+		{
+			name:         "no health check",
+			repositories: map[string]stackv1alpha1.ArtifactRepositorySpec{"main": repo(unreachable, false)},
+		},
+		{
+			name:          "all reachable",
+			repositories:  map[string]stackv1alpha1.ArtifactRepositorySpec{"main": repo(reachable, true), "logs": repo(reachable, true)},
+			wantProbing:   true,
+			wantStatus:    metav1.ConditionTrue,
+			wantInMessage: "logs: ",
+		},
+		{
+			name:          "unreachable non-default repository",
+			repositories:  map[string]stackv1alpha1.ArtifactRepositorySpec{"main": repo(reachable, true), "logs": repo(unreachable, true)},
+			wantProbing:   true,
+			wantStatus:    metav1.ConditionFalse,
+			wantInMessage: "logs: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.ArtifactRepositories = tt.repositories
+			instance.Spec.DefaultArtifactRepository = "main"
+			r := newTestReconciler(t)
+
+			if probing := r.updateArtifactReachability(instance); probing != tt.wantProbing {
+				t.Fatalf("updateArtifactReachability() = %v, want %v", probing, tt.wantProbing)
+			}
+			if !tt.wantProbing {
+				if condition := apimeta.FindStatusCondition(instance.Status.Conditions, stackv1alpha1.ConditionTypeReachable); condition != nil {
+					t.Errorf("condition set without a health check: %v", condition)
+				}
+				return
+			}
+
+			// The probes run in the background, wait for all results.
+			key := types.NamespacedName{Namespace: "ns", Name: "argo"}
+			deadline := time.Now().Add(5 * time.Second)
+			for _, target := range artifactProbeTargets(instance) {
+				for {
+					if _, ok := r.prober.result(probeKey{instance: key, repository: target.repository}, target.url); ok {
+						break
+					}
+					if time.Now().After(deadline) {
+						t.Fatalf("no probe result for %s", target.repository)
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			r.updateArtifactReachability(instance)
+
+			condition := apimeta.FindStatusCondition(instance.Status.Conditions, stackv1alpha1.ConditionTypeReachable)
+			if condition == nil || condition.Status != tt.wantStatus {
+				t.Fatalf("ArtifactRepoReachable = %v, want status %s", condition, tt.wantStatus)
+			}
+			if !strings.Contains(condition.Message, tt.wantInMessage) {
+				t.Errorf("message %q does not mention %q", condition.Message, tt.wantInMessage)
+			}
+		})
+	}
+}
+
+func TestProbeURL(t *testing.T) {
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://127.0.0.1:1/elsewhere", http.StatusFound)
+	}))
+	defer redirect.Close()
+	privateCA := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer privateCA.Close()
+	caBundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: privateCA.Certificate().Raw}))
+
+	tests := []struct {
+		name          string
+		url           string
+		caBundle      string
+		wantReachable bool
+		wantInMessage string
+	}{
+		{name: "redirect not followed", url: redirect.URL, wantReachable: true, wantInMessage: "302 Found"},
+		{name: "private ca without bundle", url: privateCA.URL, wantInMessage: "certificate"},
+		{name: "private ca with bundle", url: privateCA.URL, caBundle: caBundle, wantReachable: true, wantInMessage: "403 Forbidden"},
+		{name: "invalid bundle", url: privateCA.URL, caBundle: "not a certificate", wantInMessage: "no PEM certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := probeURL(tt.url, tt.caBundle, 5*time.Second)
+			if result.reachable != tt.wantReachable {
+				t.Errorf("reachable = %v, want %v (%s)", result.reachable, tt.wantReachable, result.message)
+			}
+			if !strings.Contains(result.message, tt.wantInMessage) {
+				t.Errorf("message %q does not mention %q", result.message, tt.wantInMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+
+	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	componentArtifactCredentials  = "artifact-credentials"
+	componentArtifactRepositories = "artifact-repositories"
+	// artifactRepositoriesConfigMap is the ConfigMap Argo looks up the
+	// artifact repositories of a namespace in.
+	artifactRepositoriesConfigMap = "artifact-repositories"
+	defaultArtifactRepoAnnotation = "workflows.argoproj.io/default-artifact-repository"
+	artifactAccessKey             = "accessKey"
+	artifactSecretKey             = "secretKey"
+)
+
+// invalidNameChars are the characters replaced when an artifact repository
+// key is turned into an object name.
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// artifactSecretName returns the name of the credentials Secret of an artifact
+// repository. Repository keys that do not form a valid object name, e.g. with
+// uppercase letters, underscores or too long, are sanitized and get a hash of
+// the key appended, so different keys keep different Secrets.
+func artifactSecretName(instance *stackv1alpha1.ArgoWorkFlow, key string) string {
+	name := instance.GetNameWithSuffix("-artifacts-" + key)
+	if len(validation.IsDNS1123Subdomain(name)) == 0 {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	suffix := "-" + hex.EncodeToString(sum[:])[:8]
+	base := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if max := validation.DNS1123SubdomainMaxLength - len(suffix); len(base) > max {
+		base = strings.TrimRight(base[:max], "-")
+	}
+	return base + suffix
+}
+
+// resolvedSpec returns a copy of the spec where artifact repositories with
+// CreateSecret reference the operator created Secret and the controller config
+// carries the effective instance ID. Explicit secret references are kept.
+func resolvedSpec(instance *stackv1alpha1.ArgoWorkFlow) *stackv1alpha1.ArgoWorkFlowSpec {
+	spec := instance.Spec.DeepCopy()
+	if id := instanceID(instance); id != "" {
+		if spec.ControllerConfig == nil {
+			spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{}
+		}
+		spec.ControllerConfig.InstanceID = id
+	}
+	for key, repo := range spec.ArtifactRepositories {
+		if repo.S3 == nil || repo.S3.CreateSecret == nil {
+			continue
+		}
+		name := artifactSecretName(instance, key)
+		if repo.S3.AccessKeySecret == nil {
+			repo.S3.AccessKeySecret = &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Key:                  artifactAccessKey,
+			}
+		}
+		if repo.S3.SecretKeySecret == nil {
+			repo.S3.SecretKeySecret = &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Key:                  artifactSecretKey,
+			}
+		}
+	}
+	return spec
+}
+
+func (r *ArgoWorkFlowReconciler) makeArtifactSecret(instance *stackv1alpha1.ArgoWorkFlow, key string, credentials *stackv1alpha1.S3CredentialsSpec, schema *runtime.Scheme) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      artifactSecretName(instance, key),
+			Namespace: instance.Namespace,
+			Labels:    makeLabels(instance, componentArtifactCredentials),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			artifactAccessKey: []byte(credentials.AccessKey),
+			artifactSecretKey: []byte(credentials.SecretKey),
+		},
+	}
+	err := ctrl.SetControllerReference(instance, secret, schema)
+	if err != nil {
+		r.Log.Error(err, "Failed to set controller reference for artifact secret")
+		return nil
+	}
+	return secret
+}
+
+// reconcileArtifactSecrets creates the credential Secrets of the artifact
+// repositories using CreateSecret and deletes the ones no longer used.
+func (r *ArgoWorkFlowReconciler) reconcileArtifactSecrets(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	wanted := map[string]bool{}
+	for key, repo := range instance.Spec.ArtifactRepositories {
+		if repo.S3 == nil || repo.S3.CreateSecret == nil {
+			continue
+		}
+		obj := r.makeArtifactSecret(instance, key, repo.S3.CreateSecret, r.Scheme)
+		if obj == nil {
+			continue
+		}
+		wanted[obj.Name] = true
+
+		err := r.Get(ctx, client.ObjectKeyFromObject(obj), &corev1.Secret{})
+		if errors.IsNotFound(err) {
+			r.Recorder.Eventf(instance, corev1.EventTypeWarning, "InlineCredentials",
+				"Storing credentials of artifact repository %q from the ArgoWorkFlow spec in Secret %s, prefer referencing an existing Secret", key, obj.Name)
+		} else if err != nil {
+			return err
+		}
+		if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
+			r.Log.Error(err, "Failed to create or update artifact secret")
+			return err
+		}
+	}
+
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, client.InNamespace(instance.Namespace), client.MatchingLabels{
+		"app.kubernetes.io/instance":  instance.Name,
+		"app.kubernetes.io/component": componentArtifactCredentials,
+	}); err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if wanted[secret.Name] || !metav1.IsControlledBy(secret, instance) {
+			continue
+		}
+		r.Log.Info("Deleting unused artifact secret", "Name", secret.Name)
+		if err := DeleteIfExists(ctx, r.Client, secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeArtifactRepositoriesConfigMap renders the artifact repositories into the
+// ConfigMap workflows of the namespace reference them from, with the default
+// repository annotated.
+func (r *ArgoWorkFlowReconciler) makeArtifactRepositoriesConfigMap(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) (*corev1.ConfigMap, error) {
+	spec := resolvedSpec(instance)
+	keys := make([]string, 0, len(spec.ArtifactRepositories))
+	for key := range spec.ArtifactRepositories {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	data := map[string]string{}
+	for _, key := range keys {
+		repo, err := makeArtifactRepositoryConfig(key, spec.ArtifactRepositories[key])
+		if err != nil {
+			return nil, err
+		}
+		rendered, err := yaml.Marshal(repo)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = string(rendered)
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      artifactRepositoriesConfigMap,
+			Namespace: instance.Namespace,
+			Labels:    makeLabels(instance, componentArtifactRepositories),
+		},
+		Data: data,
+	}
+	if spec.DefaultArtifactRepository != "" {
+		configMap.Annotations = map[string]string{
+			defaultArtifactRepoAnnotation: spec.DefaultArtifactRepository,
+		}
+	}
+	if err := ctrl.SetControllerReference(instance, configMap, schema); err != nil {
+		r.Log.Error(err, "Failed to set controller reference for artifact repositories configmap")
+		return nil, nil
+	}
+	return configMap, nil
+}
+
+// reconcileArtifactRepositories maintains the artifact repositories ConfigMap
+// of the namespace. A ConfigMap of that name not created by this instance is
+// left alone.
+func (r *ArgoWorkFlowReconciler) reconcileArtifactRepositories(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	existing := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: artifactRepositoriesConfigMap}, existing)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && !metav1.IsControlledBy(existing, instance) {
+		if len(instance.Spec.ArtifactRepositories) > 0 {
+			r.Recorder.Eventf(instance, corev1.EventTypeWarning, "ArtifactRepositoriesConflict",
+				"ConfigMap %s is not managed by this ArgoWorkFlow, the artifact repositories are not published to it", artifactRepositoriesConfigMap)
+		}
+		return nil
+	}
+
+	if len(instance.Spec.ArtifactRepositories) == 0 {
+		if err == nil {
+			return DeleteIfExists(ctx, r.Client, existing)
+		}
+		return nil
+	}
+
+	obj, err := r.makeArtifactRepositoriesConfigMap(instance, r.Scheme)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return nil
+	}
+	if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
+		r.Log.Error(err, "Failed to create or update artifact repositories configmap")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestArtifactSecretName(t *testing.T) {
+	instance := newTestArgoWorkFlow("ns", "argo")
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{name: "valid key is kept", key: "main", want: "argo-artifacts-main"},
+		{name: "uppercase", key: "Main"},
+		{name: "underscore", key: "main_repo"},
+		{name: "trailing invalid character", key: "main_"},
+		{name: "too long", key: strings.Repeat("a", 300)},
+	}
+	names := map[string]string{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := artifactSecretName(instance, tt.key)
+			if errs := validation.IsDNS1123Subdomain(got); len(errs) > 0 {
+				t.Errorf("artifactSecretName(%q) = %q is not a valid name: %v", tt.key, got, errs)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("artifactSecretName(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+			if other, ok := names[got]; ok {
+				t.Errorf("keys %q and %q share the Secret %q", other, tt.key, got)
+			}
+			names[got] = tt.key
+		})
+	}
+}
+
+func TestReconcileArtifactSecrets(t *testing.T) {
+	reference := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "s3-creds"}, Key: "accessKey"}
+	tests := []struct {
+		name       string
+		repo       stackv1alpha1.S3ArtifactRepositorySpec
+		wantSecret bool
+	}{
+		{
+			name:       "inline credentials",
+			repo:       stackv1alpha1.S3ArtifactRepositorySpec{CreateSecret: &stackv1alpha1.S3CredentialsSpec{AccessKey: "a", SecretKey: "s"}},
+			wantSecret: true,
+		},
+		{
+			name: "referenced secret",
+			repo: stackv1alpha1.S3ArtifactRepositorySpec{AccessKeySecret: reference, SecretKeySecret: reference},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			repo := tt.repo
+			repo.Endpoint, repo.Bucket = "minio:9000", "artifacts"
+			instance.Spec.ArtifactRepositories = map[string]stackv1alpha1.ArtifactRepositorySpec{"Main_Repo": {S3: &repo}}
+			r := newTestReconciler(t, instance)
+
+			if err := r.reconcileArtifactSecrets(ctx, instance); err != nil {
+				t.Fatalf("reconcileArtifactSecrets() error = %v", err)
+			}
+
+			secrets := &corev1.SecretList{}
+			if err := r.List(ctx, secrets, client.InNamespace("ns")); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(secrets.Items) == 1; got != tt.wantSecret {
+				t.Fatalf("created %d Secrets, want a Secret: %v", len(secrets.Items), tt.wantSecret)
+			}
+			selector := resolvedSpec(instance).ArtifactRepositories["Main_Repo"].S3.AccessKeySecret
+			if tt.wantSecret && selector.Name != secrets.Items[0].Name {
+				t.Errorf("repository references Secret %q, created %q", selector.Name, secrets.Items[0].Name)
+			}
+			if !tt.wantSecret && selector.Name != reference.Name {
+				t.Errorf("repository references Secret %q, want %q", selector.Name, reference.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,483 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	linkPlaceholder = regexp.MustCompile(`\$\{([^{}]*)\}`)
+	// linkVariable matches the workflow and pod fields Argo substitutes
+	// into link URLs.
+	linkVariable = regexp.MustCompile(`^(workflow\.)?(metadata\.(name|namespace|uid|labels\.[^\s]+|annotations\.[^\s]+)|status\.(startedAt|finishedAt|startedAtEpoch|finishedAtEpoch))$`)
+)
+
+// controllerConfig mirrors the parts of Argo's workflow-controller config
+// the operator renders. Field names and JSON tags follow Argo's schema.
+type controllerConfig struct {
+	ArtifactRepository *artifactRepositoryConfig  `json:"artifactRepository,omitempty"`
+	Columns            []stackv1alpha1.ColumnSpec `json:"columns,omitempty"`
+	Executor           executorConfig             `json:"executor"`
+	InstanceID         string                     `json:"instanceID,omitempty"`
+	Links              []stackv1alpha1.LinkSpec   `json:"links,omitempty"`
+	MetricsConfig      *metricsConfig             `json:"metricsConfig,omitempty"`
+	// The parallelism limits are rendered as null, i.e. unlimited.
+	NamespaceParallelism *int32                      `json:"namespaceParallelism"`
+	Parallelism          *int32                      `json:"parallelism"`
+	RetentionPolicy      *retentionPolicyConfig      `json:"retentionPolicy,omitempty"`
+	WorkflowDefaults     *workflowDefaultsConfig     `json:"workflowDefaults,omitempty"`
+	WorkflowEvents       *workflowEventsConfig       `json:"workflowEvents,omitempty"`
+	WorkflowRestrictions *workflowRestrictionsConfig `json:"workflowRestrictions,omitempty"`
+}
+
+type executorConfig struct {
+	ImagePullPolicy corev1.PullPolicy       `json:"imagePullPolicy,omitempty"`
+	Resources       executorResourcesConfig `json:"resources"`
+}
+
+type executorResourcesConfig struct {
+	Limits   map[string]string `json:"limits"`
+	Requests map[string]string `json:"requests"`
+}
+
+type artifactRepositoryConfig struct {
+	ArchiveLogs *bool                       `json:"archiveLogs,omitempty"`
+	S3          *s3ArtifactRepositoryConfig `json:"s3,omitempty"`
+}
+
+type s3ArtifactRepositoryConfig struct {
+	AccessKeySecret *corev1.SecretKeySelector `json:"accessKeySecret,omitempty"`
+	Bucket          string                    `json:"bucket"`
+	Endpoint        string                    `json:"endpoint"`
+	Insecure        *bool                     `json:"insecure,omitempty"`
+	KeyFormat       string                    `json:"keyFormat,omitempty"`
+	Region          string                    `json:"region,omitempty"`
+	SecretKeySecret *corev1.SecretKeySelector `json:"secretKeySecret,omitempty"`
+}
+
+type metricsConfig struct {
+	IgnoreErrors *bool  `json:"ignoreErrors,omitempty"`
+	Path         string `json:"path"`
+	Port         int32  `json:"port"`
+	Secure       *bool  `json:"secure,omitempty"`
+}
+
+type retentionPolicyConfig struct {
+	Completed *int32 `json:"completed,omitempty"`
+	Errored   *int32 `json:"errored,omitempty"`
+	Failed    *int32 `json:"failed,omitempty"`
+}
+
+type workflowDefaultsConfig struct {
+	Spec workflowDefaultsSpecConfig `json:"spec"`
+}
+
+type workflowDefaultsSpecConfig struct {
+	ActiveDeadlineSeconds *int64             `json:"activeDeadlineSeconds,omitempty"`
+	PodMetadata           *podMetadataConfig `json:"podMetadata,omitempty"`
+	PodPriorityClassName  string             `json:"podPriorityClassName,omitempty"`
+	PodSpecPatch          string             `json:"podSpecPatch,omitempty"`
+	ServiceAccountName    string             `json:"serviceAccountName,omitempty"`
+	// TemplateDefaults is a template fragment, kept as plain maps so the
+	// user defaults and the volume mounts can be combined.
+	TemplateDefaults map[string]interface{} `json:"templateDefaults,omitempty"`
+	Volumes          []corev1.Volume        `json:"volumes,omitempty"`
+}
+
+type podMetadataConfig struct {
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+type workflowEventsConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+type workflowRestrictionsConfig struct {
+	TemplateReferencing string `json:"templateReferencing"`
+}
+
+// BuildControllerConfig renders the workflow-controller configuration that is
+// stored under the "config" key of the controller ConfigMap.
+func BuildControllerConfig(spec *stackv1alpha1.ArgoWorkFlowSpec) ([]byte, error) {
+	config := controllerConfig{
+		Executor: executorConfig{
+			Resources: executorResourcesConfig{
+				Limits:   map[string]string{},
+				Requests: map[string]string{},
+			},
+		},
+	}
+
+	if server := spec.Server; server != nil {
+		links := make([]stackv1alpha1.LinkSpec, 0, len(server.Links)+len(server.NodeLinks))
+		links = append(links, server.Links...)
+		for _, link := range server.NodeLinks {
+			links = append(links, stackv1alpha1.LinkSpec(link))
+		}
+		for _, link := range links {
+			if err := validateLinkURL(link.URL); err != nil {
+				return nil, fmt.Errorf("invalid url for link %q: %w", link.Name, err)
+			}
+		}
+		if len(links) > 0 {
+			config.Links = links
+		}
+		config.Columns = server.Columns
+	}
+
+	if key := spec.DefaultArtifactRepository; key != "" {
+		repo, ok := spec.ArtifactRepositories[key]
+		if !ok {
+			return nil, fmt.Errorf("default artifact repository %q is not defined in artifactRepositories", key)
+		}
+		artifactRepository, err := makeArtifactRepositoryConfig(key, repo)
+		if err != nil {
+			return nil, err
+		}
+		config.ArtifactRepository = artifactRepository
+	}
+
+	if err := validateMetricsPort(spec); err != nil {
+		return nil, err
+	}
+	if metrics := spec.Metrics; metrics != nil {
+		config.MetricsConfig = &metricsConfig{
+			IgnoreErrors: metrics.IgnoreErrors,
+			Path:         metricsPath(spec),
+			Port:         metricsPort(spec),
+			Secure:       metrics.Secure,
+		}
+	}
+
+	if controllerConfig := spec.ControllerConfig; controllerConfig != nil {
+		// Only render workflowEvents when it differs from Argo's default.
+		if events := controllerConfig.WorkflowEvents; events != nil && events.Enabled != nil && !*events.Enabled {
+			config.WorkflowEvents = &workflowEventsConfig{Enabled: false}
+		}
+		if controllerConfig.InstanceID != "" {
+			if errs := validation.IsValidLabelValue(controllerConfig.InstanceID); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid instance id %q: %s", controllerConfig.InstanceID, strings.Join(errs, "; "))
+			}
+			config.InstanceID = controllerConfig.InstanceID
+		}
+		if executor := controllerConfig.Executor; executor != nil {
+			config.Executor.ImagePullPolicy = executor.ImagePullPolicy
+		}
+		if restrictions := controllerConfig.WorkflowRestrictions; restrictions != nil && restrictions.TemplateReferencing != "" {
+			config.WorkflowRestrictions = &workflowRestrictionsConfig{
+				TemplateReferencing: restrictions.TemplateReferencing,
+			}
+		}
+		if retention := controllerConfig.RetentionPolicy; retention != nil {
+			if retention.Completed != nil || retention.Failed != nil || retention.Errored != nil {
+				config.RetentionPolicy = &retentionPolicyConfig{
+					Completed: retention.Completed,
+					Errored:   retention.Errored,
+					Failed:    retention.Failed,
+				}
+			}
+		}
+		if defaults := controllerConfig.WorkflowDefaults; defaults != nil {
+			workflowDefaults, err := makeWorkflowDefaultsConfig(defaults)
+			if err != nil {
+				return nil, err
+			}
+			config.WorkflowDefaults = workflowDefaults
+		}
+	}
+
+	if controllerConfig := spec.ControllerConfig; controllerConfig != nil && controllerConfig.ConfigOverlay != nil {
+		merged, err := applyConfigOverlay(config, controllerConfig.ConfigOverlay.Raw)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateOverlayMetricsPort(spec, merged); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(merged)
+	}
+
+	return yaml.Marshal(config)
+}
+
+// makeWorkflowDefaultsConfig renders the workflow spec defaults. It returns
+// nil when no default is set.
+func makeWorkflowDefaultsConfig(defaults *stackv1alpha1.WorkflowDefaultsSpec) (*workflowDefaultsConfig, error) {
+	spec := workflowDefaultsSpecConfig{
+		ActiveDeadlineSeconds: defaults.ActiveDeadlineSeconds,
+		PodPriorityClassName:  defaults.PodPriorityClassName,
+		PodSpecPatch:          defaults.PodSpecPatch,
+		ServiceAccountName:    defaults.ServiceAccountName,
+		Volumes:               defaults.Volumes,
+	}
+
+	volumes := map[string]bool{}
+	for _, volume := range defaults.Volumes {
+		volumes[volume.Name] = true
+	}
+	for _, mount := range defaults.VolumeMounts {
+		if !volumes[mount.Name] {
+			return nil, fmt.Errorf("workflow volume mount %q does not refer to a workflow volume", mount.Name)
+		}
+	}
+	templateDefaults, err := makeTemplateDefaults(defaults)
+	if err != nil {
+		return nil, err
+	}
+	spec.TemplateDefaults = templateDefaults
+
+	if defaults.PodSpecPatch != "" {
+		var patch map[string]interface{}
+		if err := yaml.Unmarshal([]byte(defaults.PodSpecPatch), &patch); err != nil {
+			return nil, fmt.Errorf("workflow pod spec patch must be a JSON or YAML object: %w", err)
+		}
+	}
+
+	if podMetadata := defaults.PodMetadata; podMetadata != nil {
+		for key, value := range podMetadata.Labels {
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid workflow pod label key %q: %s", key, strings.Join(errs, "; "))
+			}
+			if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid workflow pod label value %q: %s", value, strings.Join(errs, "; "))
+			}
+		}
+		for key := range podMetadata.Annotations {
+			if errs := validation.IsQualifiedName(strings.ToLower(key)); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid workflow pod annotation key %q: %s", key, strings.Join(errs, "; "))
+			}
+		}
+
+		if len(podMetadata.Labels) > 0 || len(podMetadata.Annotations) > 0 {
+			spec.PodMetadata = &podMetadataConfig{
+				Annotations: podMetadata.Annotations,
+				Labels:      podMetadata.Labels,
+			}
+		}
+	}
+
+	if spec.ActiveDeadlineSeconds == nil && spec.PodMetadata == nil && spec.PodPriorityClassName == "" && spec.PodSpecPatch == "" &&
+		spec.ServiceAccountName == "" && len(spec.TemplateDefaults) == 0 && len(spec.Volumes) == 0 {
+		return nil, nil
+	}
+	return &workflowDefaultsConfig{Spec: spec}, nil
+}
+
+// makeTemplateDefaults renders the defaults of every workflow template: the
+// user template fragment with the workflow volume mounts added to its
+// container. It returns nil when neither is set.
+func makeTemplateDefaults(defaults *stackv1alpha1.WorkflowDefaultsSpec) (map[string]interface{}, error) {
+	templateDefaults := map[string]interface{}{}
+	if raw := defaults.TemplateDefaults; raw != nil && len(raw.Raw) > 0 {
+		if err := yaml.Unmarshal(raw.Raw, &templateDefaults); err != nil {
+			return nil, fmt.Errorf("workflow template defaults must be a YAML object: %w", err)
+		}
+		if templateDefaults == nil {
+			templateDefaults = map[string]interface{}{}
+		}
+		if _, ok := templateDefaults["name"]; ok {
+			return nil, fmt.Errorf("workflow template defaults cannot set a template name")
+		}
+	}
+
+	if len(defaults.VolumeMounts) > 0 {
+		container := map[string]interface{}{}
+		if value, ok := templateDefaults["container"]; ok {
+			if container, ok = value.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("workflow template defaults container must be an object")
+			}
+		}
+		if _, ok := container["volumeMounts"]; ok {
+			return nil, fmt.Errorf("workflow template defaults set container volumeMounts, use volumeMounts of the workflow defaults instead")
+		}
+		container["volumeMounts"] = defaults.VolumeMounts
+		templateDefaults["container"] = container
+	}
+
+	if len(templateDefaults) == 0 {
+		return nil, nil
+	}
+	return templateDefaults, nil
+}
+
+// applyConfigOverlay deep-merges the overlay over the generated config. The
+// overlay wins on conflicts; only maps present on both sides are merged.
+func applyConfigOverlay(config controllerConfig, overlay []byte) (map[string]interface{}, error) {
+	var overlayConfig map[string]interface{}
+	if err := yaml.Unmarshal(overlay, &overlayConfig); err != nil {
+		return nil, fmt.Errorf("config overlay must be a YAML object: %w", err)
+	}
+
+	// Convert the generated config to plain maps so nested sections merge.
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var generated map[string]interface{}
+	if err := json.Unmarshal(raw, &generated); err != nil {
+		return nil, err
+	}
+
+	mergeConfig(generated, overlayConfig)
+	return generated, nil
+}
+
+// mergeBaseConfig merges the generated config onto a base config. Settings the
+// operator renders as null, such as the unlimited parallelism, keep the base
+// value.
+func mergeBaseConfig(base, generated []byte) ([]byte, error) {
+	var baseConfig map[string]interface{}
+	if err := yaml.Unmarshal(base, &baseConfig); err != nil {
+		return nil, fmt.Errorf("base config must be a YAML object: %w", err)
+	}
+	var generatedConfig map[string]interface{}
+	if err := yaml.Unmarshal(generated, &generatedConfig); err != nil {
+		return nil, err
+	}
+	if baseConfig == nil {
+		return generated, nil
+	}
+
+	for key, value := range generatedConfig {
+		if value == nil {
+			delete(generatedConfig, key)
+		}
+	}
+	mergeConfig(baseConfig, generatedConfig)
+	return yaml.Marshal(baseConfig)
+}
+
+func mergeConfig(dst, src map[string]interface{}) {
+	for key, srcValue := range src {
+		srcMap, srcIsMap := srcValue.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeConfig(dstMap, srcMap)
+			continue
+		}
+		dst[key] = srcValue
+	}
+}
+
+// metricsPort returns the port the controller serves metrics on. The same
+// value is used in the config, on the container and on the Service.
+func metricsPort(spec *stackv1alpha1.ArgoWorkFlowSpec) int32 {
+	if spec.Metrics != nil && spec.Metrics.Port > 0 {
+		return spec.Metrics.Port
+	}
+	return defaultMetricsPort
+}
+
+func metricsPath(spec *stackv1alpha1.ArgoWorkFlowSpec) string {
+	if spec.Metrics != nil && spec.Metrics.Path != "" {
+		return spec.Metrics.Path
+	}
+	return defaultMetricsPath
+}
+
+// validateMetricsPort makes sure the metrics port does not clash with the
+// other ports of the controller container and Service.
+func validateMetricsPort(spec *stackv1alpha1.ArgoWorkFlowSpec) error {
+	port := metricsPort(spec)
+	if port == controllerHTTPPort {
+		return fmt.Errorf("metrics port %d conflicts with the controller http port", port)
+	}
+	if spec.Service != nil && port == spec.Service.Port {
+		return fmt.Errorf("metrics port %d conflicts with the service port", port)
+	}
+	return nil
+}
+
+// validateOverlayMetricsPort rejects an overlay that moves the metrics port
+// away from the one exposed on the container and Service.
+func validateOverlayMetricsPort(spec *stackv1alpha1.ArgoWorkFlowSpec, config map[string]interface{}) error {
+	metricsConfig, ok := config["metricsConfig"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	port, ok := metricsConfig["port"]
+	if !ok {
+		return nil
+	}
+	// JSON numbers decode as float64.
+	if value, ok := port.(float64); !ok || int32(value) != metricsPort(spec) {
+		return fmt.Errorf("config overlay metricsConfig.port %v does not match metrics port %d", port, metricsPort(spec))
+	}
+	return nil
+}
+
+// makeArtifactRepositoryConfig renders an artifact repository in the format
+// of Argo's artifactRepository config.
+func makeArtifactRepositoryConfig(key string, repo stackv1alpha1.ArtifactRepositorySpec) (*artifactRepositoryConfig, error) {
+	if repo.S3 == nil {
+		return nil, fmt.Errorf("artifact repository %q has no storage backend configured", key)
+	}
+
+	return &artifactRepositoryConfig{
+		ArchiveLogs: repo.ArchiveLogs,
+		S3: &s3ArtifactRepositoryConfig{
+			AccessKeySecret: repo.S3.AccessKeySecret,
+			Bucket:          repo.S3.Bucket,
+			Endpoint:        repo.S3.Endpoint,
+			Insecure:        repo.S3.Insecure,
+			KeyFormat:       repo.S3.KeyFormat,
+			Region:          repo.S3.Region,
+			SecretKeySecret: repo.S3.SecretKeySecret,
+		},
+	}, nil
+}
+
+// hashConfigData returns a stable checksum of ConfigMap data.
+func hashConfigData(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(data[key]))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// validateLinkURL checks that a link URL template is an absolute http(s) URL
+// once its ${...} placeholders are substituted.
+func validateLinkURL(template string) error {
+	for _, match := range linkPlaceholder.FindAllStringSubmatch(template, -1) {
+		if !linkVariable.MatchString(match[1]) {
+			return fmt.Errorf("unknown variable ${%s}", match[1])
+		}
+	}
+	raw := linkPlaceholder.ReplaceAllString(template, "x")
+	if strings.Contains(raw, "${") {
+		return fmt.Errorf("unterminated placeholder")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("host must not be empty")
+	}
+	return nil
+}
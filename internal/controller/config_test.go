@@ -0,0 +1,497 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+)
+
+// assertConfigKeys checks that every top-level key of want is rendered with
+// the same value in the config, and that the keys in absent are not rendered.
+func assertConfigKeys(t *testing.T, config []byte, want string, absent []string) {
+	t.Helper()
+	var got, wantConfig map[string]interface{}
+	if err := yaml.Unmarshal(config, &got); err != nil {
+		t.Fatalf("rendered config is not YAML: %v", err)
+	}
+	if err := yaml.Unmarshal([]byte(want), &wantConfig); err != nil {
+		t.Fatalf("want is not YAML: %v", err)
+	}
+	for key, value := range wantConfig {
+		if !reflect.DeepEqual(got[key], value) {
+			t.Errorf("%s = %#v, want %#v", key, got[key], value)
+		}
+	}
+	for _, key := range absent {
+		if _, ok := got[key]; ok {
+			t.Errorf("%s = %#v, want it unset", key, got[key])
+		}
+	}
+}
+
+func rawYAML(t *testing.T, value string) *runtime.RawExtension {
+	t.Helper()
+	raw, err := yaml.YAMLToJSON([]byte(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &runtime.RawExtension{Raw: raw}
+}
+
+func TestBuildControllerConfig(t *testing.T) {
+	s3 := func(bucket string) stackv1alpha1.ArtifactRepositorySpec {
+		return stackv1alpha1.ArtifactRepositorySpec{S3: &stackv1alpha1.S3ArtifactRepositorySpec{Endpoint: "minio:9000", Bucket: bucket}}
+	}
+	tests := []struct {
+		name    string
+		spec    func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec
+		want    string
+		absent  []string
+		wantErr string
+	}{
+		{
+			name: "empty spec",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec { return &stackv1alpha1.ArgoWorkFlowSpec{} },
+			want: `
+executor:
+  resources:
+    limits: {}
+    requests: {}
+parallelism: null
+namespaceParallelism: null
+`,
+			absent: []string{"artifactRepository", "links", "metricsConfig", "workflowDefaults", "workflowEvents"},
+		},
+		{
+			name: "links and node links",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{Server: &stackv1alpha1.ServerSpec{
+					Links:     []stackv1alpha1.LinkSpec{{Name: "logs", Scope: "workflow", URL: "https://logs/${metadata.name}"}},
+					NodeLinks: []stackv1alpha1.NodeLinkSpec{{Name: "pod", Scope: "pod", URL: "https://pods/${metadata.namespace}"}},
+				}}
+			},
+			want: `
+links:
+- name: logs
+  scope: workflow
+  url: https://logs/${metadata.name}
+- name: pod
+  scope: pod
+  url: https://pods/${metadata.namespace}
+`,
+		},
+		{
+			name: "link with unknown variable",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{Server: &stackv1alpha1.ServerSpec{
+					Links: []stackv1alpha1.LinkSpec{{Name: "logs", Scope: "workflow", URL: "https://logs/${spec.entrypoint}"}},
+				}}
+			},
+			wantErr: `invalid url for link "logs"`,
+		},
+		{
+			name: "default artifact repository",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{
+					ArtifactRepositories:      map[string]stackv1alpha1.ArtifactRepositorySpec{"main": s3("artifacts"), "other": s3("other")},
+					DefaultArtifactRepository: "main",
+				}
+			},
+			want: `
+artifactRepository:
+  s3:
+    bucket: artifacts
+    endpoint: minio:9000
+`,
+		},
+		{
+			name: "undefined default artifact repository",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{DefaultArtifactRepository: "main"}
+			},
+			wantErr: `default artifact repository "main" is not defined`,
+		},
+		{
+			name: "metrics",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{Metrics: &stackv1alpha1.MetricsSpec{Port: 9100, Secure: pointer.Bool(true)}}
+			},
+			want: `
+metricsConfig:
+  path: /metrics
+  port: 9100
+  secure: true
+`,
+		},
+		{
+			name: "metrics port on the controller http port",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{Metrics: &stackv1alpha1.MetricsSpec{Port: controllerHTTPPort}}
+			},
+			wantErr: "conflicts with the controller http port",
+		},
+		{
+			name: "controller settings",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					WorkflowEvents:       &stackv1alpha1.WorkflowEventsSpec{Enabled: pointer.Bool(false)},
+					InstanceID:           "team-a",
+					Executor:             &stackv1alpha1.ExecutorSpec{ImagePullPolicy: corev1.PullAlways},
+					WorkflowRestrictions: &stackv1alpha1.WorkflowRestrictionsSpec{TemplateReferencing: "Strict"},
+					RetentionPolicy:      &stackv1alpha1.RetentionPolicySpec{Completed: pointer.Int32(10)},
+				}}
+			},
+			want: `
+workflowEvents:
+  enabled: false
+instanceID: team-a
+executor:
+  imagePullPolicy: Always
+  resources:
+    limits: {}
+    requests: {}
+workflowRestrictions:
+  templateReferencing: Strict
+retentionPolicy:
+  completed: 10
+`,
+		},
+		{
+			name: "workflow events at the Argo default",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					WorkflowEvents: &stackv1alpha1.WorkflowEventsSpec{Enabled: pointer.Bool(true)},
+				}}
+			},
+			absent: []string{"workflowEvents"},
+		},
+		{
+			name: "invalid instance id",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{InstanceID: "team a"}}
+			},
+			wantErr: `invalid instance id "team a"`,
+		},
+		{
+			name: "workflow defaults",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					WorkflowDefaults: &stackv1alpha1.WorkflowDefaultsSpec{
+						ServiceAccountName: "workflow",
+						PodMetadata:        &stackv1alpha1.PodMetadataSpec{Labels: map[string]string{"team": "a"}},
+						Volumes:            []corev1.Volume{{Name: "ca"}},
+						VolumeMounts:       []corev1.VolumeMount{{Name: "ca", MountPath: "/etc/ca"}},
+						TemplateDefaults:   rawYAML(t, "timeout: 1h"),
+					},
+				}}
+			},
+			want: `
+workflowDefaults:
+  spec:
+    serviceAccountName: workflow
+    podMetadata:
+      labels:
+        team: a
+    templateDefaults:
+      timeout: 1h
+      container:
+        volumeMounts:
+        - name: ca
+          mountPath: /etc/ca
+    volumes:
+    - name: ca
+`,
+		},
+		{
+			name: "workflow defaults without a value",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					WorkflowDefaults: &stackv1alpha1.WorkflowDefaultsSpec{PodMetadata: &stackv1alpha1.PodMetadataSpec{}},
+				}}
+			},
+			absent: []string{"workflowDefaults"},
+		},
+		{
+			name: "invalid workflow pod label",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					WorkflowDefaults: &stackv1alpha1.WorkflowDefaultsSpec{PodMetadata: &stackv1alpha1.PodMetadataSpec{Labels: map[string]string{"team": "a b"}}},
+				}}
+			},
+			wantErr: `invalid workflow pod label value "a b"`,
+		},
+		{
+			name: "volume mount without a volume",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					WorkflowDefaults: &stackv1alpha1.WorkflowDefaultsSpec{VolumeMounts: []corev1.VolumeMount{{Name: "ca", MountPath: "/etc/ca"}}},
+				}}
+			},
+			wantErr: `workflow volume mount "ca" does not refer to a workflow volume`,
+		},
+		{
+			name: "pod spec patch that is not an object",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					WorkflowDefaults: &stackv1alpha1.WorkflowDefaultsSpec{PodSpecPatch: "- a"},
+				}}
+			},
+			wantErr: "workflow pod spec patch must be a JSON or YAML object",
+		},
+		{
+			name: "template defaults with a name",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					WorkflowDefaults: &stackv1alpha1.WorkflowDefaultsSpec{TemplateDefaults: rawYAML(t, "name: main")},
+				}}
+			},
+			wantErr: "cannot set a template name",
+		},
+		{
+			name: "template defaults with their own volume mounts",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					WorkflowDefaults: &stackv1alpha1.WorkflowDefaultsSpec{
+						Volumes:          []corev1.Volume{{Name: "ca"}},
+						VolumeMounts:     []corev1.VolumeMount{{Name: "ca", MountPath: "/etc/ca"}},
+						TemplateDefaults: rawYAML(t, "container: {volumeMounts: []}"),
+					},
+				}}
+			},
+			wantErr: "set container volumeMounts",
+		},
+		{
+			name: "overlay merges nested sections",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					Executor:      &stackv1alpha1.ExecutorSpec{ImagePullPolicy: corev1.PullAlways},
+					ConfigOverlay: rawYAML(t, "executor: {resources: {limits: {cpu: '1'}}}\nparallelism: 5"),
+				}}
+			},
+			want: `
+executor:
+  imagePullPolicy: Always
+  resources:
+    limits:
+      cpu: "1"
+    requests: {}
+parallelism: 5
+namespaceParallelism: null
+`,
+		},
+		{
+			name: "overlay that is not an object",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					ConfigOverlay: rawYAML(t, "- a"),
+				}}
+			},
+			wantErr: "config overlay must be a YAML object",
+		},
+		{
+			name: "overlay moving the metrics port",
+			spec: func(t *testing.T) *stackv1alpha1.ArgoWorkFlowSpec {
+				return &stackv1alpha1.ArgoWorkFlowSpec{ControllerConfig: &stackv1alpha1.ControllerConfigSpec{
+					ConfigOverlay: rawYAML(t, "metricsConfig: {port: 9100}"),
+				}}
+			},
+			wantErr: "does not match metrics port",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := BuildControllerConfig(tt.spec(t))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("BuildControllerConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildControllerConfig() error = %v", err)
+			}
+			assertConfigKeys(t, config, tt.want, tt.absent)
+		})
+	}
+}
+
+func TestMergeBaseConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		generated string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "generated keys win",
+			base:      "instanceID: base\nnodeEvents: {enabled: false}",
+			generated: "instanceID: team-a",
+			want:      "instanceID: team-a\nnodeEvents: {enabled: false}",
+		},
+		{
+			name:      "nested sections merge",
+			base:      "executor: {resources: {limits: {cpu: '1'}}, imagePullPolicy: Always}",
+			generated: "executor: {resources: {limits: {}, requests: {memory: 1Gi}}}",
+			want:      "executor: {resources: {limits: {cpu: '1'}, requests: {memory: 1Gi}}, imagePullPolicy: Always}",
+		},
+		{
+			name:      "null settings keep the base value",
+			base:      "parallelism: 10",
+			generated: "parallelism: null\nnamespaceParallelism: null",
+			want:      "parallelism: 10",
+		},
+		{
+			name:      "empty base",
+			base:      "",
+			generated: "parallelism: null",
+			want:      "parallelism: null",
+		},
+		{
+			name:      "base that is not an object",
+			base:      "- a",
+			generated: "parallelism: null",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeBaseConfig([]byte(tt.base), []byte(tt.generated))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mergeBaseConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var got, want map[string]interface{}
+			if err := yaml.Unmarshal(merged, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("mergeBaseConfig() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRenderControllerConfigBaseConfigMap(t *testing.T) {
+	base := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "base"},
+		Data:       map[string]string{"config": "parallelism: 10"},
+	}
+	tests := []struct {
+		name    string
+		objs    []*corev1.ConfigMap
+		want    string
+		wantErr string
+	}{
+		{name: "base found", objs: []*corev1.ConfigMap{base}, want: "parallelism: 10"},
+		{name: "base missing", wantErr: `base controller configmap "base" not found`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{BaseConfigMap: "base"}
+			r := newTestReconciler(t)
+			for _, obj := range tt.objs {
+				if err := r.Create(context.Background(), obj.DeepCopy()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config, err := r.renderControllerConfig(context.Background(), instance)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("renderControllerConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderControllerConfig() error = %v", err)
+			}
+			assertConfigKeys(t, config, tt.want, nil)
+		})
+	}
+}
+
+func TestApplyConfigOverlay(t *testing.T) {
+	generated := controllerConfig{
+		Executor: executorConfig{
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Resources: executorResourcesConfig{
+				Limits:   map[string]string{"cpu": "500m", "memory": "512Mi"},
+				Requests: map[string]string{"cpu": "100m"},
+			},
+		},
+		InstanceID: "team-a",
+		Links:      []stackv1alpha1.LinkSpec{{Name: "logs", Scope: "workflow", URL: "https://logs.example.com"}},
+	}
+	tests := []struct {
+		name    string
+		overlay string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "nested map merged key by key",
+			overlay: "executor: {resources: {limits: {cpu: '2'}}}",
+			want: `executor:
+  imagePullPolicy: IfNotPresent
+  resources:
+    limits: {cpu: '2', memory: 512Mi}
+    requests: {cpu: 100m}`,
+		},
+		{
+			name:    "list replaced",
+			overlay: "links: [{name: docs, scope: workflow, url: 'https://docs.example.com'}]",
+			want:    "links: [{name: docs, scope: workflow, url: 'https://docs.example.com'}]",
+		},
+		{
+			name:    "scalar overridden",
+			overlay: "instanceID: team-b",
+			want:    "instanceID: team-b",
+		},
+		{
+			name:    "map replaced by scalar",
+			overlay: "executor: null",
+			want:    "executor: null",
+		},
+		{
+			name:    "new key added",
+			overlay: "nodeEvents: {enabled: false}",
+			want:    "{nodeEvents: {enabled: false}, instanceID: team-a, parallelism: null}",
+		},
+		{
+			name:    "not an object",
+			overlay: "- a",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := applyConfigOverlay(generated, []byte(tt.overlay))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyConfigOverlay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			config, err := yaml.Marshal(merged)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertConfigKeys(t, config, tt.want, nil)
+		})
+	}
+}
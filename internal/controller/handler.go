@@ -2,17 +2,91 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"net/url"
+	"reflect"
+	"regexp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+const (
+	defaultWorkflowWorkers = 32
+	controllerHTTPPort     = 18080
+	defaultMetricsPort     = 9090
+	defaultMetricsPath     = "/metrics"
+	kubeconfigVolumeName   = "kubeconfig"
+	kubeconfigMountPath    = "/etc/argo/kubeconfig"
+	kubeconfigSecretKey    = "kubeconfig"
+	metricsTLSVolumeName   = "metrics-tls"
+	metricsTLSMountPath    = "/etc/argo/metrics-tls"
+
+	deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+	defaultPreStopSleepSeconds   = 5
+	defaultRevisionHistoryLimit  = 10
+	// pprofPort is the fixed port the workflow-controller serves pprof on.
+	pprofPort = 6060
+
+	backupLabel           = "backup"
+	configHashAnnotation  = "checksum/config"
+	istioInjectAnnotation = "sidecar.istio.io/inject"
+	unknownZone           = "unknown"
+
+	// deploymentProgressDeadlineExceeded is the reason the Deployment
+	// controller sets on the Progressing condition when a rollout times out.
+	deploymentProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	managedKeysAnnotation              = "stack.zncdata.net/managed-keys"
+	forceRecreateAnnotation            = "stack.zncdata.net/force-recreate"
+	appliedReplicasAnnotation          = "stack.zncdata.net/applied-replicas"
+	clusterRBACFinalizer               = "stack.zncdata.net/cluster-rbac"
+)
+
+const (
+	componentController = "controller"
+	componentConfigMap  = "configmap"
+	componentWorkflow   = "workflow"
+	componentMetrics    = "metrics"
+)
+
+// makeLabels returns the labels of a managed object: the standard
+// app.kubernetes.io labels for selecting everything of an instance, with the
+// CR labels on top. Selectors keep using the CR labels alone.
+func makeLabels(instance *stackv1alpha1.ArgoWorkFlow, component string) map[string]string {
+	labels := map[string]string{
+		"app.kubernetes.io/managed-by": "argo-workflow-operator",
+		"app.kubernetes.io/instance":   instance.Name,
+		"app.kubernetes.io/component":  component,
+	}
+	for key, value := range instance.GetLabels() {
+		labels[key] = value
+	}
+	return labels
+}
+
 func (r *ArgoWorkFlowReconciler) makeService(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *corev1.Service {
-	labels := instance.GetLabels()
+	labels := makeLabels(instance, componentController)
 	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        instance.Name,
@@ -27,11 +101,35 @@ func (r *ArgoWorkFlowReconciler) makeService(instance *stackv1alpha1.ArgoWorkFlo
 					Name:     "http",
 					Protocol: "TCP",
 				},
+				{
+					Port:       metricsPort(&instance.Spec),
+					Name:       "metrics",
+					Protocol:   "TCP",
+					TargetPort: intstr.FromString("metrics"),
+				},
 			},
-			Selector: labels,
+			Selector: instance.GetLabels(),
 			Type:     instance.Spec.Service.Type,
 		},
 	}
+	if pprofEnabled(instance) {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+			Port:       pprofPort,
+			Name:       "pprof",
+			Protocol:   "TCP",
+			TargetPort: intstr.FromString("pprof"),
+		})
+	}
+	if controller := instance.Spec.Controller; controller != nil && controller.ExposeExtraPorts {
+		for _, port := range controller.ExtraPorts {
+			svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+				Port:       port.ContainerPort,
+				Name:       port.Name,
+				Protocol:   port.Protocol,
+				TargetPort: intstr.FromInt(int(port.ContainerPort)),
+			})
+		}
+	}
 	err := ctrl.SetControllerReference(instance, svc, schema)
 	if err != nil {
 		r.Log.Error(err, "Failed to set controller reference for service")
@@ -53,8 +151,71 @@ func (r *ArgoWorkFlowReconciler) reconcileService(ctx context.Context, instance
 	return nil
 }
 
+// makeMetricsService returns the metrics-only Service. Its component label
+// "metrics" and port name "metrics" stay fixed so that a ServiceMonitor can
+// select it apart from the controller Service.
+func (r *ArgoWorkFlowReconciler) makeMetricsService(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *corev1.Service {
+	labels := makeLabels(instance, componentMetrics)
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      instance.GetNameWithSuffix("-metrics"),
+			Namespace: instance.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Port:       metricsPort(&instance.Spec),
+					Name:       "metrics",
+					Protocol:   "TCP",
+					TargetPort: intstr.FromString("metrics"),
+				},
+			},
+			Selector: instance.GetLabels(),
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+	err := ctrl.SetControllerReference(instance, svc, schema)
+	if err != nil {
+		r.Log.Error(err, "Failed to set controller reference for metrics service")
+		return nil
+	}
+	return svc
+}
+
+// reconcileMetricsService creates the metrics-only Service when
+// Spec.Metrics.SeparateService is set and deletes it otherwise.
+func (r *ArgoWorkFlowReconciler) reconcileMetricsService(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	if instance.Spec.Metrics == nil || !instance.Spec.Metrics.SeparateService {
+		svc := &corev1.Service{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: instance.GetNameWithSuffix("-metrics")}, svc)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if !metav1.IsControlledBy(svc, instance) {
+			return nil
+		}
+		if err := DeleteIfExists(ctx, r.Client, svc); err != nil {
+			r.Log.Error(err, "Failed to delete metrics service")
+			return err
+		}
+		return nil
+	}
+
+	obj := r.makeMetricsService(instance, r.Scheme)
+	if obj == nil {
+		return nil
+	}
+
+	if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
+		r.Log.Error(err, "Failed to create or update metrics service")
+		return err
+	}
+	return nil
+}
+
 func (r *ArgoWorkFlowReconciler) makeDeployment(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *appsv1.Deployment {
-	labels := instance.GetLabels()
+	labels := makeLabels(instance, componentController)
 	envVars := []corev1.EnvVar{}
 
 	envVars = append(envVars, corev1.EnvVar{
@@ -75,6 +236,33 @@ func (r *ArgoWorkFlowReconciler) makeDeployment(instance *stackv1alpha1.ArgoWork
 			},
 		},
 	})
+	if !leaderElectionEnabled(instance) {
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  "LEADER_ELECTION_DISABLE",
+			Value: "true",
+		})
+	}
+	if pprofEnabled(instance) {
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  "ARGO_PPROF",
+			Value: "true",
+		})
+	}
+	if endpoint := otlpEndpoint(instance); endpoint != "" {
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  "OTEL_EXPORTER_OTLP_ENDPOINT",
+			Value: endpoint,
+		})
+	}
+	if metricsTLSSecretName(instance) != "" {
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  "METRICS_TLS_CERT_FILE",
+			Value: metricsTLSMountPath + "/" + corev1.TLSCertKey,
+		}, corev1.EnvVar{
+			Name:  "METRICS_TLS_KEY_FILE",
+			Value: metricsTLSMountPath + "/" + corev1.TLSPrivateKeyKey,
+		})
+	}
 	dep := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      instance.Name,
@@ -82,44 +270,42 @@ func (r *ArgoWorkFlowReconciler) makeDeployment(instance *stackv1alpha1.ArgoWork
 			Labels:    labels,
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: &instance.Spec.Replicas,
+			Replicas:                &instance.Spec.Replicas,
+			RevisionHistoryLimit:    revisionHistoryLimit(instance),
+			ProgressDeadlineSeconds: instance.Spec.ProgressDeadlineSeconds,
+			Strategy:                makeDeploymentStrategy(instance),
+			// The selector is immutable, keep it on the CR labels only.
 			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
+				MatchLabels: instance.GetLabels(),
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels:      labels,
+					Annotations: makePodAnnotations(instance),
 				},
 				Spec: corev1.PodSpec{
 					ServiceAccountName: instance.GetNameWithSuffix("-controller"),
 					SecurityContext:    instance.Spec.SecurityContext,
+					SchedulingGates:    instance.Spec.SchedulingGates,
 					Containers: []corev1.Container{
 						{
 							Name:            instance.Name,
 							Image:           instance.Spec.Image.Repository + ":" + instance.Spec.Image.Tag,
 							ImagePullPolicy: instance.Spec.Image.PullPolicy,
-							Args: []string{
-								"--configmap",
-								instance.GetNameWithSuffix("-controller"),
-								"--executor-image",
-								"docker.io/bitnami/argo-workflow-exec:3.5.0-debian-11-r0",
-								"--executor-image-pull-policy",
-								"IfNotPresent",
-								"--loglevel",
-								"info",
-								"--gloglevel",
-								"0",
-								"--workflow-workers",
-								"32",
-							},
-							Env:       envVars,
-							Resources: *instance.Spec.Resources,
+							Args:            makeControllerArgs(instance),
+							Env:             envVars,
+							Resources:       makeControllerResources(instance),
 							Ports: []corev1.ContainerPort{
 								{
-									ContainerPort: 18080,
+									ContainerPort: controllerHTTPPort,
 									Name:          "http",
 									Protocol:      "TCP",
 								},
+								{
+									ContainerPort: metricsPort(&instance.Spec),
+									Name:          "metrics",
+									Protocol:      "TCP",
+								},
 							},
 						},
 					},
@@ -128,6 +314,58 @@ func (r *ArgoWorkFlowReconciler) makeDeployment(instance *stackv1alpha1.ArgoWork
 		},
 	}
 
+	container := &dep.Spec.Template.Spec.Containers[0]
+	if pprofEnabled(instance) {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			ContainerPort: pprofPort,
+			Name:          "pprof",
+			Protocol:      "TCP",
+		})
+	}
+	if controller := instance.Spec.Controller; controller != nil {
+		container.Ports = append(container.Ports, controller.ExtraPorts...)
+		if len(controller.Command) > 0 {
+			container.Command = controller.Command
+		}
+		if len(controller.Args) > 0 {
+			container.Args = controller.Args
+		}
+	}
+	container.Lifecycle = makeControllerLifecycle(instance)
+
+	if secret := kubeconfigSecretName(instance); secret != "" {
+		podSpec := &dep.Spec.Template.Spec
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: kubeconfigVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secret,
+				},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      kubeconfigVolumeName,
+			MountPath: kubeconfigMountPath,
+			ReadOnly:  true,
+		})
+	}
+	if secret := metricsTLSSecretName(instance); secret != "" {
+		podSpec := &dep.Spec.Template.Spec
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: metricsTLSVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secret,
+				},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      metricsTLSVolumeName,
+			MountPath: metricsTLSMountPath,
+			ReadOnly:  true,
+		})
+	}
+
 	CreateScheduler(instance, dep)
 
 	err := ctrl.SetControllerReference(instance, dep, schema)
@@ -138,147 +376,1876 @@ func (r *ArgoWorkFlowReconciler) makeDeployment(instance *stackv1alpha1.ArgoWork
 	return dep
 }
 
-func (r *ArgoWorkFlowReconciler) updateStatusConditionWithDeployment(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow, status metav1.ConditionStatus, message string) error {
-	instance.SetStatusCondition(metav1.Condition{
-		Type:               stackv1alpha1.ConditionTypeProgressing,
-		Status:             status,
-		Reason:             stackv1alpha1.ConditionReasonReconcileDeployment,
-		Message:            message,
-		ObservedGeneration: instance.GetGeneration(),
-		LastTransitionTime: metav1.Now(),
-	})
+// warnCommandOverride emits a warning event when a command or args override
+// is about to be rolled out, as it bypasses the operator managed arguments.
+func (r *ArgoWorkFlowReconciler) warnCommandOverride(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow, desired *appsv1.Deployment) error {
+	controller := instance.Spec.Controller
+	if controller == nil || (len(controller.Command) == 0 && len(controller.Args) == 0) {
+		return nil
+	}
 
-	if err := r.UpdateStatus(ctx, instance); err != nil {
+	current := &appsv1.Deployment{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), current)
+	if client.IgnoreNotFound(err) != nil {
 		return err
 	}
+	want := desired.Spec.Template.Spec.Containers[0]
+	if err == nil && len(current.Spec.Template.Spec.Containers) > 0 {
+		have := current.Spec.Template.Spec.Containers[0]
+		if reflect.DeepEqual(have.Command, want.Command) && reflect.DeepEqual(have.Args, want.Args) {
+			return nil
+		}
+	}
+	r.Recorder.Event(instance, corev1.EventTypeWarning, "CommandOverridden",
+		"Controller command or args are overridden, operator managed arguments such as --configmap are not applied")
 	return nil
 }
 
-func (r *ArgoWorkFlowReconciler) reconcileDeployment(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
-	obj := r.makeDeployment(instance, r.Scheme)
-	if obj == nil {
+// makeControllerLifecycle returns the controller container lifecycle. Leader
+// elected controllers default to a preStop sleep.
+func makeControllerLifecycle(instance *stackv1alpha1.ArgoWorkFlow) *corev1.Lifecycle {
+	if controller := instance.Spec.Controller; controller != nil && controller.Lifecycle != nil {
+		return controller.Lifecycle
+	}
+	if !leaderElectionEnabled(instance) {
 		return nil
 	}
-	if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
-		logger.Error(err, "Failed to create or update deployment")
-		return err
+	return &corev1.Lifecycle{
+		PreStop: &corev1.LifecycleHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"sleep", strconv.Itoa(defaultPreStopSleepSeconds)},
+			},
+		},
 	}
+}
 
-	return nil
+func leaderElectionEnabled(instance *stackv1alpha1.ArgoWorkFlow) bool {
+	le := instance.Spec.LeaderElection
+	return le == nil || le.Enabled == nil || *le.Enabled
 }
 
-func (r *ArgoWorkFlowReconciler) makeServiceAccount(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *corev1.ServiceAccount {
-	labels := instance.GetLabels()
-	satoken := true
-	sa := &corev1.ServiceAccount{
+// leaseName is the name of the Lease the workflow-controller elects its
+// leader with, suffixed with the instance ID like Argo does.
+func leaseName(instance *stackv1alpha1.ArgoWorkFlow) string {
+	if id := instanceID(instance); id != "" {
+		return "workflow-controller-" + id
+	}
+	return "workflow-controller"
+}
+
+func (r *ArgoWorkFlowReconciler) makeLease(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *coordinationv1.Lease {
+	lease := &coordinationv1.Lease{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      instance.GetNameWithSuffix("-controller"),
+			Name:      leaseName(instance),
 			Namespace: instance.Namespace,
-			Labels:    labels,
+			Labels:    makeLabels(instance, componentController),
 		},
-		AutomountServiceAccountToken: &satoken,
 	}
-	err := ctrl.SetControllerReference(instance, sa, schema)
+	err := ctrl.SetControllerReference(instance, lease, schema)
 	if err != nil {
-		r.Log.Error(err, "Failed to set controller reference for ServiceAccount")
+		r.Log.Error(err, "Failed to set controller reference for lease")
 		return nil
 	}
-	return sa
+	return lease
 }
 
-func (r *ArgoWorkFlowReconciler) reconcileServiceAccount(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
-	obj := r.makeServiceAccount(instance, r.Scheme)
+// reconcileLease creates the controller Lease when PreCreateLease is set. An
+// existing Lease is never updated, the controller owns its spec.
+func (r *ArgoWorkFlowReconciler) reconcileLease(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	le := instance.Spec.LeaderElection
+	if le == nil || !le.PreCreateLease || !leaderElectionEnabled(instance) {
+		return nil
+	}
+	obj := r.makeLease(instance, r.Scheme)
 	if obj == nil {
 		return nil
 	}
 
-	if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
-		r.Log.Error(err, "Failed to create or update ServiceAccount")
+	err := r.Get(ctx, client.ObjectKeyFromObject(obj), &coordinationv1.Lease{})
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	r.Log.Info("Creating controller lease", "Name", obj.Name)
+	if err := r.Create(ctx, obj); err != nil && !errors.IsAlreadyExists(err) {
 		return err
 	}
 	return nil
 }
 
-func (r *ArgoWorkFlowReconciler) makeClusterRoleBinding(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *rbacv1.ClusterRoleBinding {
-	labels := instance.GetLabels()
-	subject := rbacv1.Subject{
-		Kind:      "ServiceAccount",
-		Name:      instance.GetNameWithSuffix("-controller"),
-		Namespace: instance.Namespace,
+// recreateSelected reports whether the Recreate strategy is picked
+// automatically: a single leader elected replica would otherwise briefly run
+// next to its replacement during a RollingUpdate.
+func recreateSelected(instance *stackv1alpha1.ArgoWorkFlow) bool {
+	return instance.Spec.Strategy == nil && instance.Spec.Replicas == 1 && leaderElectionEnabled(instance)
+}
+
+// revisionHistoryLimit returns Spec.RevisionHistoryLimit, or the CRD default
+// for objects stored before the field was defaulted.
+func revisionHistoryLimit(instance *stackv1alpha1.ArgoWorkFlow) *int32 {
+	if instance.Spec.RevisionHistoryLimit != nil {
+		return instance.Spec.RevisionHistoryLimit
 	}
-	subjects := []rbacv1.Subject{subject}
-	crbd := &rbacv1.ClusterRoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      instance.GetNameWithSuffix("-controller"),
-			Namespace: instance.Namespace,
-			Labels:    labels,
+	limit := int32(defaultRevisionHistoryLimit)
+	return &limit
+}
+
+func makeDeploymentStrategy(instance *stackv1alpha1.ArgoWorkFlow) appsv1.DeploymentStrategy {
+	if instance.Spec.Strategy != nil {
+		return *instance.Spec.Strategy
+	}
+	if recreateSelected(instance) {
+		return appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	}
+	return appsv1.DeploymentStrategy{Type: appsv1.RollingUpdateDeploymentStrategyType}
+}
+
+// resourceProfiles are the presets selectable with Spec.ResourceProfile.
+var resourceProfiles = map[string]corev1.ResourceRequirements{
+	"small":  makeResourceRequirements("100m", "128Mi", "500m", "512Mi"),
+	"medium": makeResourceRequirements("250m", "256Mi", "1", "1Gi"),
+	"large":  makeResourceRequirements("500m", "512Mi", "2", "2Gi"),
+}
+
+func makeResourceRequirements(requestCPU, requestMemory, limitCPU, limitMemory string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(requestCPU),
+			corev1.ResourceMemory: resource.MustParse(requestMemory),
 		},
-		RoleRef: rbacv1.RoleRef{
-			APIGroup: "rbac.authorization.k8s.io",
-			Kind:     "ClusterRole",
-			Name:     "manager-role",
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(limitCPU),
+			corev1.ResourceMemory: resource.MustParse(limitMemory),
 		},
-		Subjects: subjects,
 	}
-	err := ctrl.SetControllerReference(instance, crbd, schema)
-	if err != nil {
-		r.Log.Error(err, "Failed to set controller reference for ClusterRoleBinding")
+}
+
+// makeControllerResources returns the controller container resources. Explicit
+// Spec.Resources always win over Spec.ResourceProfile.
+func makeControllerResources(instance *stackv1alpha1.ArgoWorkFlow) corev1.ResourceRequirements {
+	if instance.Spec.Resources != nil {
+		return *instance.Spec.Resources
+	}
+	if profile, ok := resourceProfiles[instance.Spec.ResourceProfile]; ok {
+		return *profile.DeepCopy()
+	}
+	return corev1.ResourceRequirements{}
+}
+
+// operatorPodAnnotations are the pod template annotations the operator sets
+// itself in reconcileDeployment.
+var operatorPodAnnotations = map[string]bool{
+	configHashAnnotation: true,
+}
+
+// makePodAnnotations returns the user annotations of the controller pod
+// template. Keys of operatorPodAnnotations are left out: the operator sets
+// those on top, or leaves them unset like the checksum with HotReload.
+func makePodAnnotations(instance *stackv1alpha1.ArgoWorkFlow) map[string]string {
+	annotations := make(map[string]string, len(instance.Spec.PodAnnotations)+1)
+	for key, value := range instance.Spec.PodAnnotations {
+		if operatorPodAnnotations[key] {
+			continue
+		}
+		annotations[key] = value
+	}
+	return annotations
+}
+
+// imageVersion matches the release at the start of an image tag, as in
+// "3.5.0", "v3.5.0" or "3.5.0-debian-11-r0".
+var imageVersion = regexp.MustCompile(`^v?(\d+\.\d+\.\d+)`)
+
+// argoVersion returns the Argo release of an image tag, or "unknown" when the
+// tag does not name one.
+func argoVersion(tag string) string {
+	match := imageVersion.FindStringSubmatch(tag)
+	if match == nil {
+		return "unknown"
+	}
+	return "v" + match[1]
+}
+
+// minOTLPArgoVersion is the first Argo release whose workflow-controller
+// exports telemetry to OTEL_EXPORTER_OTLP_ENDPOINT.
+const minOTLPArgoVersion = "v3.6.0"
+
+// argoVersionBefore reports whether an image tag names an Argo release older
+// than version. Tags without a release, such as latest, are never older.
+func argoVersionBefore(tag, version string) bool {
+	current := imageVersion.FindStringSubmatch(tag)
+	minimum := imageVersion.FindStringSubmatch(version)
+	if current == nil || minimum == nil {
+		return false
+	}
+	currentParts, minimumParts := strings.Split(current[1], "."), strings.Split(minimum[1], ".")
+	for i := range currentParts {
+		a, _ := strconv.Atoi(currentParts[i])
+		b, _ := strconv.Atoi(minimumParts[i])
+		if a != b {
+			return a < b
+		}
+	}
+	return false
+}
+
+// makeControllerArgs returns the workflow-controller command line arguments.
+func makeControllerArgs(instance *stackv1alpha1.ArgoWorkFlow) []string {
+	workflowWorkers := int32(defaultWorkflowWorkers)
+	var podWorkers, podCleanupWorkers, workflowTTLWorkers int32
+	if controllerConfig := instance.Spec.ControllerConfig; controllerConfig != nil {
+		if controllerConfig.WorkflowWorkers > 0 {
+			workflowWorkers = controllerConfig.WorkflowWorkers
+		}
+		podWorkers = controllerConfig.PodWorkers
+		podCleanupWorkers = controllerConfig.PodCleanupWorkers
+		workflowTTLWorkers = controllerConfig.WorkflowTTLWorkers
+	}
+
+	args := []string{
+		"--configmap",
+		controllerConfigMapName(instance),
+		"--executor-image",
+		"docker.io/bitnami/argo-workflow-exec:3.5.0-debian-11-r0",
+	}
+	// The flag takes precedence over the executor config, only pass it when
+	// the config leaves the pull policy unset.
+	if executorPullPolicy(instance) == "" {
+		args = append(args, "--executor-image-pull-policy", "IfNotPresent")
+	}
+	args = append(args,
+		"--loglevel",
+		controllerLogLevel(instance),
+		"--gloglevel",
+		"0",
+		"--workflow-workers",
+		strconv.Itoa(int(workflowWorkers)),
+	)
+	if kubeconfigSecretName(instance) != "" {
+		args = append(args, "--kubeconfig", kubeconfigMountPath+"/"+kubeconfigSecretKey)
+	}
+	if podWorkers > 0 {
+		args = append(args, "--pod-workers", strconv.Itoa(int(podWorkers)))
+	}
+	if podCleanupWorkers > 0 {
+		args = append(args, "--pod-cleanup-workers", strconv.Itoa(int(podCleanupWorkers)))
+	}
+	if workflowTTLWorkers > 0 {
+		args = append(args, "--workflow-ttl-workers", strconv.Itoa(int(workflowTTLWorkers)))
+	}
+	return args
+}
+
+// executorPullPolicy returns the executor image pull policy set in the
+// controller config, if any.
+func executorPullPolicy(instance *stackv1alpha1.ArgoWorkFlow) corev1.PullPolicy {
+	controllerConfig := instance.Spec.ControllerConfig
+	if controllerConfig == nil || controllerConfig.Executor == nil {
+		return ""
+	}
+	return controllerConfig.Executor.ImagePullPolicy
+}
+
+// controllerLogLevel returns the controller log level, falling back from the
+// controller setting to the top-level default.
+func controllerLogLevel(instance *stackv1alpha1.ArgoWorkFlow) string {
+	if controller := instance.Spec.Controller; controller != nil && controller.LogLevel != "" {
+		return controller.LogLevel
+	}
+	if instance.Spec.LogLevel != "" {
+		return instance.Spec.LogLevel
+	}
+	return "info"
+}
+
+func otlpEndpoint(instance *stackv1alpha1.ArgoWorkFlow) string {
+	controllerConfig := instance.Spec.ControllerConfig
+	if controllerConfig == nil || controllerConfig.Telemetry == nil {
+		return ""
+	}
+	return controllerConfig.Telemetry.OTLPEndpoint
+}
+
+func validateOTLPEndpoint(instance *stackv1alpha1.ArgoWorkFlow) error {
+	endpoint := otlpEndpoint(instance)
+	if endpoint == "" {
 		return nil
 	}
-	return crbd
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid otlp endpoint %q: %w", endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid otlp endpoint %q: must be an http or https url", endpoint)
+	}
+	return nil
 }
 
-func (r *ArgoWorkFlowReconciler) reconcileClusterRoleBinding(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
-	obj := r.makeClusterRoleBinding(instance, r.Scheme)
-	if obj == nil {
+// validateExtraPorts checks that the extra controller ports collide neither
+// with each other nor with the ports the operator manages.
+func validateExtraPorts(instance *stackv1alpha1.ArgoWorkFlow) error {
+	if instance.Spec.Controller == nil {
 		return nil
 	}
+	names := map[string]bool{"http": true, "metrics": true}
+	numbers := map[int32]string{controllerHTTPPort: "http", metricsPort(&instance.Spec): "metrics"}
+	if pprofEnabled(instance) {
+		names["pprof"] = true
+		numbers[pprofPort] = "pprof"
+	}
+	if instance.Spec.Controller.ExposeExtraPorts && instance.Spec.Service != nil {
+		numbers[instance.Spec.Service.Port] = "service"
+	}
+	for _, port := range instance.Spec.Controller.ExtraPorts {
+		if port.Name == "" && instance.Spec.Controller.ExposeExtraPorts {
+			return fmt.Errorf("extra port %d needs a name to be exposed on the service", port.ContainerPort)
+		}
+		if port.Name != "" && names[port.Name] {
+			return fmt.Errorf("extra port name %q is already in use", port.Name)
+		}
+		if other, ok := numbers[port.ContainerPort]; ok {
+			return fmt.Errorf("extra port %d conflicts with the %s port", port.ContainerPort, other)
+		}
+		names[port.Name] = true
+		numbers[port.ContainerPort] = port.Name
+	}
+	return nil
+}
 
-	if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
-		r.Log.Error(err, "Failed to create or update ServiceAccount")
+func pprofEnabled(instance *stackv1alpha1.ArgoWorkFlow) bool {
+	controllerConfig := instance.Spec.ControllerConfig
+	return controllerConfig != nil && controllerConfig.PProf != nil && controllerConfig.PProf.Enabled
+}
+
+func kubeconfigSecretName(instance *stackv1alpha1.ArgoWorkFlow) string {
+	if instance.Spec.ControllerConfig == nil {
+		return ""
+	}
+	return instance.Spec.ControllerConfig.KubeconfigSecret
+}
+
+// validateKubeconfigSecret checks that the referenced kubeconfig Secret exists
+// and has the key passed to --kubeconfig.
+func (r *ArgoWorkFlowReconciler) validateKubeconfigSecret(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	name := kubeconfigSecretName(instance)
+	if name == "" {
+		return nil
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: name}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("kubeconfig secret %q not found", name)
+		}
 		return err
 	}
+	if len(secret.Data[kubeconfigSecretKey]) == 0 {
+		return fmt.Errorf("kubeconfig secret %q has no %q key", name, kubeconfigSecretKey)
+	}
 	return nil
 }
 
-func (r *ArgoWorkFlowReconciler) makeConfigMap(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *corev1.ConfigMap {
-	labels := instance.GetLabels()
+// metricsTLSSecretName returns the Secret with the metrics certificate, or ""
+// when metrics are not served over TLS or use a self-signed certificate.
+func metricsTLSSecretName(instance *stackv1alpha1.ArgoWorkFlow) string {
+	metrics := instance.Spec.Metrics
+	if metrics == nil || metrics.Secure == nil || !*metrics.Secure {
+		return ""
+	}
+	return metrics.TLSSecret
+}
 
-	configMap := &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      instance.GetNameWithSuffix("-controller"),
-			Namespace: instance.Namespace,
-			Labels:    labels,
-		},
-		Data: map[string]string{
-			"config": `
-parallelism:
-namespaceParallelism:
-executor:
-  resources:
-    limits: {}
-    requests: {}
-`,
-		},
+// validateMetricsTLSSecret checks that the metrics TLS Secret exists and has
+// both the certificate and the key, and reports the result in the
+// MetricsTLSReady condition. The controller pod would otherwise not start.
+func (r *ArgoWorkFlowReconciler) validateMetricsTLSSecret(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	name := metricsTLSSecretName(instance)
+	if name == "" {
+		apimeta.RemoveStatusCondition(&instance.Status.Conditions, stackv1alpha1.ConditionTypeMetricsTLS)
+		return nil
 	}
 
-	err := ctrl.SetControllerReference(instance, configMap, schema)
-	if err != nil {
-		r.Log.Error(err, "Failed to set controller reference for configmap")
+	err := func() error {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: name}, secret); err != nil {
+			if errors.IsNotFound(err) {
+				return fmt.Errorf("metrics tls secret %q not found", name)
+			}
+			return err
+		}
+		for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+			if len(secret.Data[key]) == 0 {
+				return fmt.Errorf("metrics tls secret %q has no %q key", name, key)
+			}
+		}
 		return nil
+	}()
+	if err != nil {
+		instance.SetStatusCondition(metav1.Condition{
+			Type:               stackv1alpha1.ConditionTypeMetricsTLS,
+			Status:             metav1.ConditionFalse,
+			Reason:             stackv1alpha1.ConditionReasonTLSSecretMissing,
+			Message:            err.Error(),
+			ObservedGeneration: instance.GetGeneration(),
+		})
+		return err
 	}
-	return configMap
+
+	instance.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeMetricsTLS,
+		Status:             metav1.ConditionTrue,
+		Reason:             stackv1alpha1.ConditionReasonTLSSecretFound,
+		Message:            "Metrics TLS secret found",
+		ObservedGeneration: instance.GetGeneration(),
+	})
+	return nil
 }
 
-func (r *ArgoWorkFlowReconciler) reconcileConfigMap(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
-	obj := r.makeConfigMap(ctx, instance, r.Scheme)
+// updateStatusConditionWithDeployment sets the Progressing condition for the
+// Deployment. Like every status change it is written once by Reconcile.
+func (r *ArgoWorkFlowReconciler) updateStatusConditionWithDeployment(instance *stackv1alpha1.ArgoWorkFlow, status metav1.ConditionStatus, message string) {
+	instance.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeProgressing,
+		Status:             status,
+		Reason:             stackv1alpha1.ConditionReasonReconcileDeployment,
+		Message:            message,
+		ObservedGeneration: instance.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+	})
+}
+
+func (r *ArgoWorkFlowReconciler) reconcileDeployment(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	if err := validateExtraPorts(instance); err != nil {
+		r.Log.Error(err, "Invalid controller extra ports")
+		return err
+	}
+
+	if err := validateOTLPEndpoint(instance); err != nil {
+		r.Log.Error(err, "Invalid OTLP endpoint")
+		return err
+	}
+
+	if err := r.validateKubeconfigSecret(ctx, instance); err != nil {
+		r.Log.Error(err, "Invalid kubeconfig secret")
+		return err
+	}
+
+	if err := r.validateMetricsTLSSecret(ctx, instance); err != nil {
+		r.Log.Error(err, "Invalid metrics tls secret")
+		return err
+	}
+
+	obj := r.makeDeployment(instance, r.Scheme)
 	if obj == nil {
 		return nil
 	}
 
-	if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
-		r.Log.Error(err, "Failed to create or update service")
+	// Stamp the config checksum on the pod template so config changes roll
+	// the controller pods.
+	configHash, err := r.controllerConfigHash(ctx, instance)
+	if err != nil {
 		return err
 	}
-	return nil
+	if !hotReload(instance) {
+		obj.Spec.Template.Annotations[configHashAnnotation] = configHash
+	}
+	instance.Status.ConfigHash = configHash
+	instance.Status.ArgoVersion = argoVersion(instance.Spec.Image.Tag)
+	instance.Status.PProfPort = 0
+	if pprofEnabled(instance) {
+		instance.Status.PProfPort = pprofPort
+	}
+
+	if err := r.warnCommandOverride(ctx, instance, obj); err != nil {
+		return err
+	}
+
+	if err := r.migrateDeploymentSelector(ctx, instance, obj); err != nil {
+		return err
+	}
+
+	if err := r.handleManualScale(ctx, instance, obj); err != nil {
+		return err
+	}
+
+	if recreateSelected(instance) {
+		current := &appsv1.Deployment{}
+		err := r.Get(ctx, client.ObjectKeyFromObject(obj), current)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		if err != nil || current.Spec.Strategy.Type != appsv1.RecreateDeploymentStrategyType {
+			r.Recorder.Event(instance, corev1.EventTypeNormal, "RecreateStrategySelected",
+				"Using the Recreate strategy: a single leader elected controller must not run next to its replacement during a rolling update")
+		}
+	}
+
+	if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
+		logger.Error(err, "Failed to create or update deployment")
+		return err
+	}
+
+	if instance.Spec.PruneReplicaSets {
+		if err := r.pruneReplicaSets(ctx, instance); err != nil {
+			r.Log.Error(err, "Failed to prune stale replicasets")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pruneReplicaSets deletes the scaled down ReplicaSets of the controller
+// Deployment beyond the revision history limit, which the Deployment
+// controller occasionally leaves behind after rollbacks.
+func (r *ArgoWorkFlowReconciler) pruneReplicaSets(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: instance.Name}, deployment); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	replicaSets := &appsv1.ReplicaSetList{}
+	if err := r.List(ctx, replicaSets, client.InNamespace(instance.Namespace), client.MatchingLabels(instance.GetLabels())); err != nil {
+		return err
+	}
+
+	limit := int(*revisionHistoryLimit(instance))
+	for _, stale := range staleReplicaSets(deployment, replicaSets.Items, limit) {
+		r.Log.Info("Deleting stale replicaset", "Namespace", stale.Namespace, "Name", stale.Name)
+		if err := DeleteIfExists(ctx, r.Client, stale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// staleReplicaSets returns the ReplicaSets controlled by the Deployment that
+// have no replicas left and fall outside the newest limit revisions.
+func staleReplicaSets(deployment *appsv1.Deployment, replicaSets []appsv1.ReplicaSet, limit int) []*appsv1.ReplicaSet {
+	var inactive []*appsv1.ReplicaSet
+	for i := range replicaSets {
+		rs := &replicaSets[i]
+		if !metav1.IsControlledBy(rs, deployment) || rs.DeletionTimestamp != nil {
+			continue
+		}
+		if (rs.Spec.Replicas != nil && *rs.Spec.Replicas > 0) || rs.Status.Replicas > 0 {
+			continue
+		}
+		inactive = append(inactive, rs)
+	}
+	if len(inactive) <= limit {
+		return nil
+	}
+
+	sort.Slice(inactive, func(i, j int) bool {
+		return replicaSetRevision(inactive[i]) > replicaSetRevision(inactive[j])
+	})
+	return inactive[limit:]
+}
+
+func replicaSetRevision(rs *appsv1.ReplicaSet) int64 {
+	revision, err := strconv.ParseInt(rs.Annotations[deploymentRevisionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return revision
+}
+
+// renderControllerConfig renders the generated controller config, merged onto
+// the base ConfigMap if one is set.
+func (r *ArgoWorkFlowReconciler) renderControllerConfig(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) ([]byte, error) {
+	config, err := BuildControllerConfig(resolvedSpec(instance))
+	if err != nil {
+		return nil, err
+	}
+	if instance.Spec.ControllerConfig == nil || instance.Spec.ControllerConfig.BaseConfigMap == "" {
+		return config, nil
+	}
+
+	name := instance.Spec.ControllerConfig.BaseConfigMap
+	base := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: name}, base); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("base controller configmap %q not found", name)
+		}
+		return nil, err
+	}
+	merged, err := mergeBaseConfig([]byte(base.Data["config"]), config)
+	if err != nil {
+		return nil, fmt.Errorf("base controller configmap %q: %w", name, err)
+	}
+	return merged, nil
+}
+
+// controllerConfigHash returns the checksum of the config the controller reads,
+// either the generated one or the data of the existing ConfigMap.
+func (r *ArgoWorkFlowReconciler) controllerConfigHash(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) (string, error) {
+	if existing := existingConfigMapName(instance); existing != "" {
+		configMap := &corev1.ConfigMap{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: existing}, configMap); err != nil {
+			if errors.IsNotFound(err) {
+				return "", fmt.Errorf("existing controller configmap %q not found", existing)
+			}
+			return "", err
+		}
+		return hashConfigData(configMap.Data), nil
+	}
+
+	config, err := r.renderControllerConfig(ctx, instance)
+	if err != nil {
+		return "", err
+	}
+	return hashConfigData(map[string]string{"config": string(config)}), nil
+}
+
+// requiredArgoKinds are the argoproj.io kinds, at the served version, that the
+// deployed workflow-controller expects to be installed.
+var requiredArgoKinds = []string{
+	"Workflow",
+	"WorkflowTemplate",
+	"ClusterWorkflowTemplate",
+	"CronWorkflow",
+	"WorkflowTaskSet",
+	"WorkflowTaskResult",
+	"WorkflowArtifactGCTask",
+}
+
+const argoAPIVersion = "v1alpha1"
+
+// checkArgoCRDs sets the MigrationNeeded condition when argoproj.io kinds the
+// controller needs are not served by the API server. It is skipped when
+// discovery is not permitted or fails.
+func (r *ArgoWorkFlowReconciler) checkArgoCRDs(instance *stackv1alpha1.ArgoWorkFlow) {
+	var missing []string
+	for _, kind := range requiredArgoKinds {
+		_, err := r.RESTMapper().RESTMapping(schema.GroupKind{Group: "argoproj.io", Kind: kind}, argoAPIVersion)
+		if err == nil {
+			continue
+		}
+		if !apimeta.IsNoMatchError(err) {
+			r.Log.Info("Skipping Argo CRD check, discovery failed", "error", err.Error())
+			return
+		}
+		missing = append(missing, kind)
+	}
+
+	if len(missing) > 0 {
+		instance.SetStatusCondition(metav1.Condition{
+			Type:   stackv1alpha1.ConditionTypeMigration,
+			Status: metav1.ConditionTrue,
+			Reason: stackv1alpha1.ConditionReasonCRDsOutdated,
+			Message: fmt.Sprintf("argoproj.io/%s CRDs missing for %s, install the CRDs matching the controller image",
+				argoAPIVersion, strings.Join(missing, ", ")),
+			ObservedGeneration: instance.GetGeneration(),
+		})
+		return
+	}
+	instance.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeMigration,
+		Status:             metav1.ConditionFalse,
+		Reason:             stackv1alpha1.ConditionReasonCRDsUpToDate,
+		Message:            "Installed Argo CRDs match the controller",
+		ObservedGeneration: instance.GetGeneration(),
+	})
+}
+
+// checkEventBus sets the EventBusAvailable condition for the referenced
+// EventBus. Without the Argo Events CRDs the reference cannot be checked and
+// the condition is Unknown.
+func (r *ArgoWorkFlowReconciler) checkEventBus(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	name := instance.Spec.EventBusRef
+	if name == "" {
+		apimeta.RemoveStatusCondition(&instance.Status.Conditions, stackv1alpha1.ConditionTypeEventBus)
+		return nil
+	}
+
+	condition := metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeEventBus,
+		Status:             metav1.ConditionTrue,
+		Reason:             stackv1alpha1.ConditionReasonEventBusFound,
+		Message:            fmt.Sprintf("EventBus %s found", name),
+		ObservedGeneration: instance.GetGeneration(),
+	}
+	gvk := schema.GroupVersionKind{Group: "argoproj.io", Version: argoAPIVersion, Kind: "EventBus"}
+	if _, err := r.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+		if !apimeta.IsNoMatchError(err) {
+			return err
+		}
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = stackv1alpha1.ConditionReasonEventsNotInstalled
+		condition.Message = fmt.Sprintf("Argo Events CRDs are not installed, EventBus %s cannot be checked", name)
+		instance.SetStatusCondition(condition)
+		return nil
+	}
+
+	eventBus := &unstructured.Unstructured{}
+	eventBus.SetGroupVersionKind(gvk)
+	err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: name}, eventBus)
+	if errors.IsNotFound(err) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = stackv1alpha1.ConditionReasonEventBusMissing
+		condition.Message = fmt.Sprintf("EventBus %s not found in namespace %s", name, instance.Namespace)
+	} else if err != nil {
+		return err
+	}
+	instance.SetStatusCondition(condition)
+	return nil
+}
+
+// checkUnsupportedConfig reports spec settings Argo cannot apply in the
+// UnsupportedConfig condition, instead of ignoring them silently. With several
+// of them the reason is the first one's and the messages are joined.
+func (r *ArgoWorkFlowReconciler) checkUnsupportedConfig(instance *stackv1alpha1.ArgoWorkFlow) {
+	var reasons, messages []string
+	if len(instance.Spec.NamespaceParallelismOverrides) > 0 {
+		reasons = append(reasons, stackv1alpha1.ConditionReasonNamespaceOverrides)
+		messages = append(messages, "namespaceParallelismOverrides are not applied, the workflow-controller supports a single namespaceParallelism only; use one ArgoWorkFlow per managed namespace instead")
+	}
+	if otlpEndpoint(instance) != "" && argoVersionBefore(instance.Spec.Image.Tag, minOTLPArgoVersion) {
+		reasons = append(reasons, stackv1alpha1.ConditionReasonOTLPUnsupported)
+		messages = append(messages, fmt.Sprintf("telemetry.otlpEndpoint is ignored by Argo %s, the workflow-controller reads OTEL_EXPORTER_OTLP_ENDPOINT from %s on",
+			argoVersion(instance.Spec.Image.Tag), minOTLPArgoVersion))
+	}
+
+	if len(reasons) == 0 {
+		apimeta.RemoveStatusCondition(&instance.Status.Conditions, stackv1alpha1.ConditionTypeUnsupported)
+		return
+	}
+	instance.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeUnsupported,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasons[0],
+		Message:            strings.Join(messages, "; "),
+		ObservedGeneration: instance.GetGeneration(),
+	})
+}
+
+// instanceID returns the instance ID the controller runs with. Without an
+// explicit one it defaults to <namespace>-<name>, which no other instance
+// shares. An existing ConfigMap brings its own config, so there is no default
+// then.
+func instanceID(instance *stackv1alpha1.ArgoWorkFlow) string {
+	if id := explicitInstanceID(instance); id != "" {
+		return id
+	}
+	if existingConfigMapName(instance) != "" {
+		return ""
+	}
+	return defaultInstanceID(instance)
+}
+
+func explicitInstanceID(instance *stackv1alpha1.ArgoWorkFlow) string {
+	if instance.Spec.ControllerConfig == nil {
+		return ""
+	}
+	return instance.Spec.ControllerConfig.InstanceID
+}
+
+// defaultInstanceID returns <namespace>-<name>. IDs longer than a label value
+// allows are shortened and get a hash of the full ID appended.
+func defaultInstanceID(instance *stackv1alpha1.ArgoWorkFlow) string {
+	id := instance.Namespace + "-" + instance.Name
+	if len(id) <= validation.LabelValueMaxLength {
+		return id
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	suffix := "-" + hex.EncodeToString(sum[:])[:8]
+	base := strings.TrimRight(id[:validation.LabelValueMaxLength-len(suffix)], "-.")
+	return base + suffix
+}
+
+// checkInstanceID sets the InstanceIDConflict condition when another
+// ArgoWorkFlow in the cluster uses the explicitly set instance ID, so that
+// both controllers would process the same workflows. Default IDs are unique
+// and need no check.
+func (r *ArgoWorkFlowReconciler) checkInstanceID(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	id := explicitInstanceID(instance)
+	if id == "" {
+		apimeta.RemoveStatusCondition(&instance.Status.Conditions, stackv1alpha1.ConditionTypeInstanceID)
+		return nil
+	}
+
+	instances := &stackv1alpha1.ArgoWorkFlowList{}
+	if err := r.List(ctx, instances); err != nil {
+		return err
+	}
+
+	var conflicts []string
+	for i := range instances.Items {
+		other := &instances.Items[i]
+		if other.UID == instance.UID || !other.DeletionTimestamp.IsZero() || instanceID(other) != id {
+			continue
+		}
+		conflicts = append(conflicts, other.Namespace+"/"+other.Name)
+	}
+	sort.Strings(conflicts)
+
+	if len(conflicts) == 0 {
+		instance.SetStatusCondition(metav1.Condition{
+			Type:               stackv1alpha1.ConditionTypeInstanceID,
+			Status:             metav1.ConditionFalse,
+			Reason:             stackv1alpha1.ConditionReasonUniqueInstanceID,
+			Message:            "No other ArgoWorkFlow uses this instance ID",
+			ObservedGeneration: instance.GetGeneration(),
+		})
+		return nil
+	}
+	message := fmt.Sprintf("Instance ID %q is also used by %s, their controllers process the same workflows", id, strings.Join(conflicts, ", "))
+	instance.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeInstanceID,
+		Status:             metav1.ConditionTrue,
+		Reason:             stackv1alpha1.ConditionReasonDuplicateInstanceID,
+		Message:            message,
+		ObservedGeneration: instance.GetGeneration(),
+	})
+	return nil
+}
+
+// updateZoneReadiness counts the ready controller pods per topology zone of
+// the nodes they run on.
+func (r *ArgoWorkFlowReconciler) updateZoneReadiness(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(instance.Namespace), client.MatchingLabels(instance.GetLabels())); err != nil {
+		return err
+	}
+
+	zones := map[string]int32{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || !isPodReady(&pod) {
+			continue
+		}
+		node := &corev1.Node{}
+		if err := r.Get(ctx, client.ObjectKey{Name: pod.Spec.NodeName}, node); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		zone, ok := node.Labels[corev1.LabelTopologyZone]
+		if !ok {
+			zone = unknownZone
+		}
+		zones[zone]++
+	}
+	instance.Status.ZoneReadiness = zones
+	return nil
+}
+
+// migrateDeploymentSelector deletes the existing Deployment when its selector
+// differs from the desired one, so it can be created again. Selectors are
+// immutable and the update would fail otherwise.
+func (r *ArgoWorkFlowReconciler) migrateDeploymentSelector(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow, desired *appsv1.Deployment) error {
+	current := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), current); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if equality.Semantic.DeepEqual(current.Spec.Selector, desired.Spec.Selector) {
+		return nil
+	}
+
+	if !instance.Spec.AllowSelectorMigration {
+		return fmt.Errorf("deployment %q selector changed and is immutable, set allowSelectorMigration to recreate the deployment", current.Name)
+	}
+	r.Recorder.Eventf(instance, corev1.EventTypeWarning, "SelectorMigration",
+		"Recreating Deployment %s because its selector changed, the controller is unavailable until the new pods are ready", current.Name)
+	r.Log.Info("Recreating deployment for selector change", "Name", current.Name)
+	return DeleteIfExists(ctx, r.Client, current)
+}
+
+// handleManualScale detects a Deployment scaled outside of the operator: its
+// replicas differ from the spec.replicas last applied, which is recorded in
+// an annotation. The manual scale is kept with IgnoreManualScale and
+// overridden otherwise. A changed spec.replicas always wins.
+func (r *ArgoWorkFlowReconciler) handleManualScale(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow, desired *appsv1.Deployment) error {
+	specReplicas := strconv.Itoa(int(instance.Spec.Replicas))
+	if desired.Annotations == nil {
+		desired.Annotations = map[string]string{}
+	}
+	desired.Annotations[appliedReplicasAnnotation] = specReplicas
+
+	current := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), current); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	applied, ok := current.Annotations[appliedReplicasAnnotation]
+	if !ok || applied != specReplicas || current.Spec.Replicas == nil || *current.Spec.Replicas == instance.Spec.Replicas {
+		return nil
+	}
+
+	if instance.Spec.IgnoreManualScale {
+		r.Log.V(1).Info("Keeping manually scaled replicas", "Name", current.Name, "replicas", *current.Spec.Replicas)
+		desired.Spec.Replicas = current.Spec.Replicas
+		return nil
+	}
+	r.Recorder.Eventf(instance, corev1.EventTypeNormal, "ManualScaleOverridden",
+		"Deployment %s was scaled to %d replicas outside of the ArgoWorkFlow, restoring spec.replicas %d; set ignoreManualScale to keep manual scaling",
+		current.Name, *current.Spec.Replicas, instance.Spec.Replicas)
+	return nil
+}
+
+// controllerReplicas returns the replicas of the controller Deployment, which
+// differ from spec.replicas while a manual scale is kept.
+func (r *ArgoWorkFlowReconciler) controllerReplicas(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) (int32, error) {
+	dep := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: instance.Name}, dep); err != nil {
+		return 0, err
+	}
+	if dep.Spec.Replicas == nil {
+		return 1, nil
+	}
+	return *dep.Spec.Replicas, nil
+}
+
+// readyConfigPods counts the ready controller pods that run the current
+// config, i.e. carry the config checksum recorded in Status.ConfigHash.
+func (r *ArgoWorkFlowReconciler) readyConfigPods(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) (int32, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(instance.Namespace), client.MatchingLabels(instance.GetLabels())); err != nil {
+		return 0, err
+	}
+	configHash := instance.Status.ConfigHash
+	if hotReload(instance) {
+		// Pods reload the config in place and carry no checksum.
+		configHash = ""
+	}
+	return countReadyConfigPods(pods.Items, configHash), nil
+}
+
+func hotReload(instance *stackv1alpha1.ArgoWorkFlow) bool {
+	return instance.Spec.ControllerConfig != nil && instance.Spec.ControllerConfig.HotReload
+}
+
+func countReadyConfigPods(pods []corev1.Pod, configHash string) int32 {
+	var ready int32
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || !isPodReady(pod) || pod.Annotations[configHashAnnotation] != configHash {
+			continue
+		}
+		ready++
+	}
+	return ready
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// checkDeploymentRollout reports whether the controller Deployment finished
+// rolling out the current spec and updates the Progressing condition.
+func (r *ArgoWorkFlowReconciler) checkDeploymentRollout(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) (bool, error) {
+	dep := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: instance.Name}, dep); err != nil {
+		return false, err
+	}
+
+	desired := int32(1)
+	if dep.Spec.Replicas != nil {
+		desired = *dep.Spec.Replicas
+	}
+
+	checkPodQuota(instance, dep)
+
+	// The Deployment controller reports a timed out rollout once
+	// progressDeadlineSeconds has passed without progress.
+	for _, condition := range dep.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse &&
+			condition.Reason == deploymentProgressDeadlineExceeded {
+			instance.SetStatusCondition(metav1.Condition{
+				Type:               stackv1alpha1.ConditionTypeProgressing,
+				Status:             metav1.ConditionFalse,
+				Reason:             stackv1alpha1.ConditionReasonProgressDeadline,
+				Message:            condition.Message,
+				ObservedGeneration: instance.GetGeneration(),
+			})
+			return false, nil
+		}
+	}
+
+	if dep.Status.ObservedGeneration < dep.Generation || dep.Status.UpdatedReplicas != desired {
+		instance.SetStatusCondition(metav1.Condition{
+			Type:               stackv1alpha1.ConditionTypeProgressing,
+			Status:             metav1.ConditionTrue,
+			Reason:             stackv1alpha1.ConditionReasonReconcileDeployment,
+			Message:            fmt.Sprintf("Deployment rollout in progress: %d of %d replicas updated", dep.Status.UpdatedReplicas, desired),
+			ObservedGeneration: instance.GetGeneration(),
+		})
+		return false, nil
+	}
+
+	instance.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeProgressing,
+		Status:             metav1.ConditionFalse,
+		Reason:             stackv1alpha1.ConditionReasonRunning,
+		Message:            "Deployment rollout complete",
+		ObservedGeneration: instance.GetGeneration(),
+	})
+	return true, nil
+}
+
+// isQuotaExceeded reports whether the API server rejected an object because a
+// ResourceQuota of the namespace is exhausted.
+func isQuotaExceeded(err error) bool {
+	return errors.IsForbidden(err) && strings.Contains(err.Error(), "exceeded quota")
+}
+
+// setQuotaExceeded reports an exhausted ResourceQuota in the QuotaExceeded
+// condition.
+func setQuotaExceeded(instance *stackv1alpha1.ArgoWorkFlow, message string) {
+	instance.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeQuota,
+		Status:             metav1.ConditionTrue,
+		Reason:             stackv1alpha1.ConditionReasonQuotaExceeded,
+		Message:            message,
+		ObservedGeneration: instance.GetGeneration(),
+	})
+}
+
+// checkPodQuota sets the QuotaExceeded condition from the Deployment, whose
+// ReplicaFailure condition carries the quota error when controller pods
+// could not be created.
+func checkPodQuota(instance *stackv1alpha1.ArgoWorkFlow, dep *appsv1.Deployment) {
+	for _, condition := range dep.Status.Conditions {
+		if condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue &&
+			strings.Contains(condition.Message, "exceeded quota") {
+			setQuotaExceeded(instance, condition.Message)
+			return
+		}
+	}
+	instance.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeQuota,
+		Status:             metav1.ConditionFalse,
+		Reason:             stackv1alpha1.ConditionReasonWithinQuota,
+		Message:            "All resources were created within the resource quota",
+		ObservedGeneration: instance.GetGeneration(),
+	})
+}
+
+func (r *ArgoWorkFlowReconciler) makeServiceAccount(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *corev1.ServiceAccount {
+	labels := makeLabels(instance, componentController)
+	satoken := true
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      instance.GetNameWithSuffix("-controller"),
+			Namespace: instance.Namespace,
+			Labels:    labels,
+		},
+		AutomountServiceAccountToken: &satoken,
+	}
+	err := ctrl.SetControllerReference(instance, sa, schema)
+	if err != nil {
+		r.Log.Error(err, "Failed to set controller reference for ServiceAccount")
+		return nil
+	}
+	return sa
+}
+
+func (r *ArgoWorkFlowReconciler) reconcileServiceAccount(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	obj := r.makeServiceAccount(instance, r.Scheme)
+	if obj == nil {
+		return nil
+	}
+
+	if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
+		r.Log.Error(err, "Failed to create or update ServiceAccount")
+		return err
+	}
+	return nil
+}
+
+func (r *ArgoWorkFlowReconciler) makeClusterRoleBinding(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *rbacv1.ClusterRoleBinding {
+	labels := makeLabels(instance, componentController)
+	subject := rbacv1.Subject{
+		Kind:      "ServiceAccount",
+		Name:      instance.GetNameWithSuffix("-controller"),
+		Namespace: instance.Namespace,
+	}
+	subjects := []rbacv1.Subject{subject}
+	crbd := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clusterRBACName(instance),
+			Namespace: instance.Namespace,
+			Labels:    labels,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "ClusterRole",
+			Name:     clusterRoleName(instance),
+		},
+		Subjects: subjects,
+	}
+	err := ctrl.SetControllerReference(instance, crbd, schema)
+	if err != nil {
+		r.Log.Error(err, "Failed to set controller reference for ClusterRoleBinding")
+		return nil
+	}
+	return crbd
+}
+
+func (r *ArgoWorkFlowReconciler) reconcileClusterRoleBinding(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	if err := r.reconcileClusterRole(ctx, instance); err != nil {
+		r.Log.Error(err, "Failed to reconcile ClusterRole")
+		return err
+	}
+
+	obj := r.makeClusterRoleBinding(instance, r.Scheme)
+	if obj == nil {
+		return nil
+	}
+
+	// The roleRef of a binding is immutable, recreate it when it changes.
+	current := &rbacv1.ClusterRoleBinding{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(obj), current)
+	if err == nil && current.RoleRef != obj.RoleRef {
+		if err := DeleteIfExists(ctx, r.Client, current); err != nil {
+			r.Log.Error(err, "Failed to delete ClusterRoleBinding")
+			return err
+		}
+	} else if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+
+	if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
+		r.Log.Error(err, "Failed to create or update ServiceAccount")
+		return err
+	}
+	if err := r.deleteLegacyClusterRBAC(ctx, instance); err != nil {
+		r.Log.Error(err, "Failed to delete legacy cluster RBAC")
+		return err
+	}
+	return nil
+}
+
+// workflowServiceAccountName is the ServiceAccount workflows of the instance
+// namespace run as unless they set their own.
+func workflowServiceAccountName(instance *stackv1alpha1.ArgoWorkFlow) string {
+	controllerConfig := instance.Spec.ControllerConfig
+	if controllerConfig != nil && controllerConfig.WorkflowDefaults != nil && controllerConfig.WorkflowDefaults.ServiceAccountName != "" {
+		return controllerConfig.WorkflowDefaults.ServiceAccountName
+	}
+	return "default"
+}
+
+func workflowExtraRules(instance *stackv1alpha1.ArgoWorkFlow) []rbacv1.PolicyRule {
+	if instance.Spec.RBAC == nil {
+		return nil
+	}
+	return instance.Spec.RBAC.ExtraRules
+}
+
+// workflowAgentRules let the agent pod of HTTP and plugin templates, which
+// runs as the workflow ServiceAccount, read its WorkflowTaskSet and report
+// the task results.
+var workflowAgentRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{"argoproj.io"},
+		Resources: []string{"workflowtasksets"},
+		Verbs:     []string{"list", "watch"},
+	},
+	{
+		APIGroups: []string{"argoproj.io"},
+		Resources: []string{"workflowtasksets/status"},
+		Verbs:     []string{"patch"},
+	},
+}
+
+// extraRuleResources are the resources extra RBAC rules may grant, per API
+// group. The operator holds escalate and bind on Roles, so this list is the
+// boundary of what editors of an ArgoWorkFlow can grant the workflow
+// ServiceAccount: Argo workflow objects and the core resources workflow steps
+// commonly use. Secrets and RBAC objects are left out on purpose. Granting
+// create on pods or workflows still lets workflows run as any ServiceAccount
+// of the namespace.
+var extraRuleResources = map[string][]string{
+	"argoproj.io": {
+		"workflows", "workflowtemplates", "cronworkflows",
+		"workflowtaskresults", "workflowtasksets", "workflowtasksets/status",
+		"workflowartifactgctasks", "workflowartifactgctasks/status",
+	},
+	"": {"pods", "pods/log", "configmaps", "persistentvolumeclaims", "events"},
+}
+
+// validateExtraRules checks that every extra rule grants verbs on resources of
+// extraRuleResources; non-resource URLs cannot be granted by a namespaced Role.
+func validateExtraRules(rules []rbacv1.PolicyRule) error {
+	for i, rule := range rules {
+		if len(rule.Verbs) == 0 {
+			return fmt.Errorf("extra rbac rule %d has no verbs", i)
+		}
+		if len(rule.Resources) == 0 {
+			return fmt.Errorf("extra rbac rule %d has no resources", i)
+		}
+		if len(rule.NonResourceURLs) > 0 {
+			return fmt.Errorf("extra rbac rule %d sets nonResourceURLs, which a Role cannot grant", i)
+		}
+		if len(rule.APIGroups) == 0 {
+			return fmt.Errorf("extra rbac rule %d has no apiGroups", i)
+		}
+		for _, group := range rule.APIGroups {
+			allowed, ok := extraRuleResources[group]
+			if !ok {
+				return fmt.Errorf("extra rbac rule %d grants API group %q, which extra rules may not grant", i, group)
+			}
+			for _, resource := range rule.Resources {
+				found := false
+				for _, name := range allowed {
+					found = found || name == resource
+				}
+				if !found {
+					return fmt.Errorf("extra rbac rule %d grants %q in API group %q, which extra rules may not grant", i, resource, group)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (r *ArgoWorkFlowReconciler) makeWorkflowRole(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *rbacv1.Role {
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      instance.GetNameWithSuffix("-workflow"),
+			Namespace: instance.Namespace,
+			Labels:    makeLabels(instance, componentWorkflow),
+		},
+		Rules: append(append([]rbacv1.PolicyRule{}, workflowAgentRules...), workflowExtraRules(instance)...),
+	}
+	err := ctrl.SetControllerReference(instance, role, schema)
+	if err != nil {
+		r.Log.Error(err, "Failed to set controller reference for Role")
+		return nil
+	}
+	return role
+}
+
+func (r *ArgoWorkFlowReconciler) makeWorkflowRoleBinding(instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) *rbacv1.RoleBinding {
+	rb := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      instance.GetNameWithSuffix("-workflow"),
+			Namespace: instance.Namespace,
+			Labels:    makeLabels(instance, componentWorkflow),
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "Role",
+			Name:     instance.GetNameWithSuffix("-workflow"),
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      workflowServiceAccountName(instance),
+				Namespace: instance.Namespace,
+			},
+		},
+	}
+	err := ctrl.SetControllerReference(instance, rb, schema)
+	if err != nil {
+		r.Log.Error(err, "Failed to set controller reference for RoleBinding")
+		return nil
+	}
+	return rb
+}
+
+// reconcileWorkflowRole grants the workflow agent rules and the extra RBAC
+// rules to the workflow ServiceAccount.
+func (r *ArgoWorkFlowReconciler) reconcileWorkflowRole(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	if err := validateExtraRules(workflowExtraRules(instance)); err != nil {
+		return err
+	}
+
+	role := r.makeWorkflowRole(instance, r.Scheme)
+	if role == nil {
+		return nil
+	}
+	if err := CreateOrUpdate(ctx, r.Client, role); err != nil {
+		r.Log.Error(err, "Failed to create or update Role")
+		return err
+	}
+
+	rb := r.makeWorkflowRoleBinding(instance, r.Scheme)
+	if rb == nil {
+		return nil
+	}
+	if err := CreateOrUpdate(ctx, r.Client, rb); err != nil {
+		r.Log.Error(err, "Failed to create or update RoleBinding")
+		return err
+	}
+	return nil
+}
+
+// clusterRoleRules are the permissions of the workflow-controller.
+var clusterRoleRules = []rbacv1.PolicyRule{
+	{APIGroups: []string{""}, Resources: []string{"pods", "pods/exec"}, Verbs: []string{"get", "list", "watch", "create", "update", "patch", "delete"}},
+	{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "watch", "list"}},
+	{APIGroups: []string{""}, Resources: []string{"persistentvolumeclaims", "persistentvolumeclaims/finalizers"}, Verbs: []string{"get", "create", "update", "delete"}},
+	{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+	{APIGroups: []string{""}, Resources: []string{"serviceaccounts"}, Verbs: []string{"get", "list"}},
+	{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+	{APIGroups: []string{"argoproj.io"}, Resources: []string{"workflows", "workflows/finalizers", "workflowtasksets", "workflowtasksets/finalizers", "workflowartifactgctasks"}, Verbs: []string{"get", "list", "watch", "update", "patch", "delete", "create"}},
+	{APIGroups: []string{"argoproj.io"}, Resources: []string{"workflowtemplates", "workflowtemplates/finalizers", "clusterworkflowtemplates", "clusterworkflowtemplates/finalizers"}, Verbs: []string{"get", "list", "watch"}},
+	{APIGroups: []string{"argoproj.io"}, Resources: []string{"workflowtaskresults"}, Verbs: []string{"list", "watch", "deletecollection"}},
+	{APIGroups: []string{"argoproj.io"}, Resources: []string{"cronworkflows", "cronworkflows/finalizers"}, Verbs: []string{"get", "list", "watch", "update", "patch", "delete"}},
+	{APIGroups: []string{"policy"}, Resources: []string{"poddisruptionbudgets"}, Verbs: []string{"create", "get", "delete"}},
+	{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: []string{"create", "get", "update"}},
+}
+
+// clusterRBACName is the name of the cluster scoped ClusterRole and
+// ClusterRoleBinding of the instance. It carries the namespace, so instances
+// of the same name in different namespaces do not share them.
+func clusterRBACName(instance *stackv1alpha1.ArgoWorkFlow) string {
+	return instance.Namespace + "-" + instance.GetNameWithSuffix("-controller")
+}
+
+// clusterRoleName returns the ClusterRole the controller is bound to.
+func clusterRoleName(instance *stackv1alpha1.ArgoWorkFlow) string {
+	if createClusterRole(instance) {
+		return clusterRBACName(instance)
+	}
+	return "manager-role"
+}
+
+func createClusterRole(instance *stackv1alpha1.ArgoWorkFlow) bool {
+	return instance.Spec.RBAC != nil && instance.Spec.RBAC.CreateClusterRole
+}
+
+// makeClusterRole returns the ClusterRole of the controller. It carries no
+// owner reference, a cluster scoped object cannot be owned by the namespaced
+// ArgoWorkFlow; the cluster RBAC finalizer deletes it instead.
+func (r *ArgoWorkFlowReconciler) makeClusterRole(instance *stackv1alpha1.ArgoWorkFlow) *rbacv1.ClusterRole {
+	return &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   clusterRoleName(instance),
+			Labels: makeLabels(instance, componentController),
+		},
+		Rules: clusterRoleRules,
+	}
+}
+
+// reconcileClusterRole creates the controller ClusterRole when requested and
+// guards it with the cluster RBAC finalizer. When the option is turned off the
+// ClusterRole is deleted and the finalizer removed.
+func (r *ArgoWorkFlowReconciler) reconcileClusterRole(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	if !createClusterRole(instance) {
+		return r.deleteClusterRole(ctx, instance)
+	}
+
+	if controllerutil.AddFinalizer(instance, clusterRBACFinalizer) {
+		if err := r.updateFinalizers(ctx, instance); err != nil {
+			return err
+		}
+	}
+
+	return CreateOrUpdate(ctx, r.Client, r.makeClusterRole(instance))
+}
+
+// deleteClusterRole deletes the ClusterRole created for the instance, if any,
+// and removes the cluster RBAC finalizer.
+func (r *ArgoWorkFlowReconciler) deleteClusterRole(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	if !controllerutil.ContainsFinalizer(instance, clusterRBACFinalizer) {
+		return nil
+	}
+
+	clusterRole := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: clusterRBACName(instance),
+		},
+	}
+	if err := DeleteIfExists(ctx, r.Client, clusterRole); err != nil {
+		return err
+	}
+
+	controllerutil.RemoveFinalizer(instance, clusterRBACFinalizer)
+	return r.updateFinalizers(ctx, instance)
+}
+
+// deleteLegacyClusterRBAC deletes the ClusterRoleBinding, and the ClusterRole
+// it references, that older operator versions named <name>-controller without
+// the namespace. The binding is only taken for the instance's when it binds the
+// controller ServiceAccount of the instance.
+func (r *ArgoWorkFlowReconciler) deleteLegacyClusterRBAC(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	legacyName := instance.GetNameWithSuffix("-controller")
+	binding := &rbacv1.ClusterRoleBinding{}
+	err := r.Get(ctx, client.ObjectKey{Name: legacyName}, binding)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	owned := false
+	for _, subject := range binding.Subjects {
+		if subject.Kind == "ServiceAccount" && subject.Name == legacyName && subject.Namespace == instance.Namespace {
+			owned = true
+		}
+	}
+	if !owned {
+		return nil
+	}
+
+	r.Log.Info("Deleting legacy cluster RBAC", "Name", legacyName)
+	if binding.RoleRef.Name == legacyName {
+		clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: legacyName}}
+		if err := DeleteIfExists(ctx, r.Client, clusterRole); err != nil {
+			return err
+		}
+	}
+	return DeleteIfExists(ctx, r.Client, binding)
+}
+
+// updateFinalizers writes the finalizers of the instance. The status computed
+// so far in this reconcile is kept, Update would reset it to the stored one.
+func (r *ArgoWorkFlowReconciler) updateFinalizers(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	status := instance.Status.DeepCopy()
+	if err := r.Update(ctx, instance); err != nil {
+		return err
+	}
+	instance.Status = *status
+	return nil
+}
+
+func (r *ArgoWorkFlowReconciler) makeConfigMap(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow, schema *runtime.Scheme) (*corev1.ConfigMap, error) {
+	labels := makeLabels(instance, componentConfigMap)
+
+	config, err := r.renderControllerConfig(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generatedConfigMapName(instance),
+			Namespace: instance.Namespace,
+			Labels:    labels,
+		},
+		Data: map[string]string{
+			"config": string(config),
+		},
+	}
+
+	err = ctrl.SetControllerReference(instance, configMap, schema)
+	if err != nil {
+		r.Log.Error(err, "Failed to set controller reference for configmap")
+		return nil, nil
+	}
+	return configMap, nil
+}
+
+// controllerConfigMapName returns the name of the ConfigMap the controller
+// reads its configuration from.
+func controllerConfigMapName(instance *stackv1alpha1.ArgoWorkFlow) string {
+	if existing := existingConfigMapName(instance); existing != "" {
+		return existing
+	}
+	return generatedConfigMapName(instance)
+}
+
+// generatedConfigMapName returns the name of the ConfigMap the operator
+// renders the controller config into.
+func generatedConfigMapName(instance *stackv1alpha1.ArgoWorkFlow) string {
+	if instance.Spec.ConfigMap != nil && instance.Spec.ConfigMap.Name != "" {
+		return instance.Spec.ConfigMap.Name
+	}
+	return instance.GetNameWithSuffix("-controller")
+}
+
+func existingConfigMapName(instance *stackv1alpha1.ArgoWorkFlow) string {
+	if instance.Spec.ControllerConfig == nil {
+		return ""
+	}
+	return instance.Spec.ControllerConfig.ExistingConfigMap
+}
+
+// artifactRepositoryType returns the backend of the default artifact
+// repository as reported in the status.
+func artifactRepositoryType(instance *stackv1alpha1.ArgoWorkFlow) string {
+	if existingConfigMapName(instance) != "" {
+		return "unknown"
+	}
+	repo, ok := instance.Spec.ArtifactRepositories[instance.Spec.DefaultArtifactRepository]
+	switch {
+	case instance.Spec.DefaultArtifactRepository == "" || !ok:
+		return "none"
+	case repo.S3 != nil:
+		return "s3"
+	default:
+		return "unknown"
+	}
+}
+
+func (r *ArgoWorkFlowReconciler) reconcileConfigMap(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	if instance.Spec.ConfigMap != nil && instance.Spec.ConfigMap.MirrorName != "" && instance.Spec.ConfigMap.MirrorName == generatedConfigMapName(instance) {
+		err := fmt.Errorf("configmap mirror name %q is the name of the controller configmap", instance.Spec.ConfigMap.MirrorName)
+		r.Log.Error(err, "Invalid configmap mirror name")
+		return err
+	}
+
+	if err := r.cleanupConfigMapMirrors(ctx, instance); err != nil {
+		r.Log.Error(err, "Failed to clean up configmap mirrors")
+		return err
+	}
+
+	instance.Status.ArtifactRepositoryType = artifactRepositoryType(instance)
+
+	if existing := existingConfigMapName(instance); existing != "" {
+		configMap := &corev1.ConfigMap{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: existing}, configMap); err != nil {
+			if errors.IsNotFound(err) {
+				return fmt.Errorf("existing controller configmap %q not found", existing)
+			}
+			return err
+		}
+
+		// The generated ConfigMap is no longer used once an existing one is referenced.
+		for _, name := range []string{generatedConfigMapName(instance), instance.Status.ConfigMapName} {
+			if name == "" || name == existing {
+				continue
+			}
+			if err := r.deleteStaleConfigMap(ctx, instance, name); err != nil {
+				r.Log.Error(err, "Failed to delete generated configmap")
+				return err
+			}
+		}
+		instance.Status.ConfigMapName = ""
+		return nil
+	}
+
+	if err := r.reconcileArtifactSecrets(ctx, instance); err != nil {
+		r.Log.Error(err, "Failed to reconcile artifact secrets")
+		return err
+	}
+
+	if err := r.validateArtifactSecrets(ctx, instance); err != nil {
+		r.Log.Error(err, "Invalid artifact repository secrets")
+		return err
+	}
+
+	if err := r.validateWorkflowPriorityClass(ctx, instance); err != nil {
+		r.Log.Error(err, "Invalid workflow pod priority class")
+		return err
+	}
+
+	if err := r.validateWorkflowServiceAccount(ctx, instance); err != nil {
+		r.Log.Error(err, "Invalid workflow service account")
+		return err
+	}
+
+	if err := r.checkWorkflowVolumeConfigMaps(ctx, instance); err != nil {
+		return err
+	}
+
+	obj, err := r.makeConfigMap(ctx, instance, r.Scheme)
+	if err != nil {
+		r.Log.Error(err, "Failed to render controller config")
+		return err
+	}
+	if obj == nil {
+		return nil
+	}
+
+	if err := r.handleImmutableConfigMap(ctx, instance, obj); err != nil {
+		return err
+	}
+
+	if err := r.mergeForeignConfigMapKeys(ctx, obj); err != nil {
+		r.Log.Error(err, "Failed to read current configmap")
+		return err
+	}
+
+	if err := CreateOrUpdate(ctx, r.Client, obj); err != nil {
+		r.Log.Error(err, "Failed to create or update service")
+		return err
+	}
+
+	if previous := instance.Status.ConfigMapName; previous != "" && previous != obj.Name {
+		if err := r.deleteStaleConfigMap(ctx, instance, previous); err != nil {
+			r.Log.Error(err, "Failed to delete renamed configmap")
+			return err
+		}
+	}
+	instance.Status.ConfigMapName = obj.Name
+
+	if instance.Spec.ConfigMap != nil && instance.Spec.ConfigMap.MirrorName != "" {
+		mirror := r.makeConfigMapMirror(instance, obj, r.Scheme)
+		if mirror == nil {
+			return nil
+		}
+		if err := r.reconcileConfigMapMirror(ctx, instance, mirror); err != nil {
+			r.Log.Error(err, "Failed to create or update configmap mirror")
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileConfigMapMirror creates the immutable mirror ConfigMap. A mirror
+// that no longer matches the controller config is deleted and created again,
+// since immutable ConfigMaps cannot be updated.
+func (r *ArgoWorkFlowReconciler) reconcileConfigMapMirror(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow, mirror *corev1.ConfigMap) error {
+	current := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(mirror), current)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		if !metav1.IsControlledBy(current, instance) {
+			return fmt.Errorf("configmap %q already exists and is not a mirror of this ArgoWorkFlow", mirror.Name)
+		}
+		if current.Immutable != nil && *current.Immutable && equality.Semantic.DeepEqual(current.Data, mirror.Data) {
+			return nil
+		}
+		r.Log.Info("Recreating configmap mirror", "Name", mirror.Name)
+		if err := DeleteIfExists(ctx, r.Client, current); err != nil {
+			return err
+		}
+	}
+	return CreateOrUpdate(ctx, r.Client, mirror)
+}
+
+// validateArtifactSecrets checks that the secrets and keys referenced by the
+// default artifact repository exist, and reports the result in the
+// ArtifactRepositoryReady condition. The controller would otherwise crash-loop
+// on a missing secret.
+func (r *ArgoWorkFlowReconciler) validateArtifactSecrets(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	spec := resolvedSpec(instance)
+	repo, ok := spec.ArtifactRepositories[spec.DefaultArtifactRepository]
+	if spec.DefaultArtifactRepository == "" || !ok || repo.S3 == nil {
+		return nil
+	}
+
+	for _, selector := range []*corev1.SecretKeySelector{repo.S3.AccessKeySecret, repo.S3.SecretKeySecret} {
+		if selector == nil || (selector.Optional != nil && *selector.Optional) {
+			continue
+		}
+		if err := r.checkSecretKey(ctx, instance.Namespace, selector); err != nil {
+			instance.SetStatusCondition(metav1.Condition{
+				Type:               stackv1alpha1.ConditionTypeArtifacts,
+				Status:             metav1.ConditionFalse,
+				Reason:             stackv1alpha1.ConditionReasonSecretMissing,
+				Message:            err.Error(),
+				ObservedGeneration: instance.GetGeneration(),
+			})
+			return err
+		}
+	}
+
+	instance.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeArtifacts,
+		Status:             metav1.ConditionTrue,
+		Reason:             stackv1alpha1.ConditionReasonSecretsFound,
+		Message:            "Artifact repository secrets found",
+		ObservedGeneration: instance.GetGeneration(),
+	})
+	return nil
+}
+
+// validateWorkflowPriorityClass checks that the PriorityClass set for workflow
+// pods exists, otherwise every workflow pod would be rejected.
+func (r *ArgoWorkFlowReconciler) validateWorkflowPriorityClass(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	controllerConfig := instance.Spec.ControllerConfig
+	if controllerConfig == nil || controllerConfig.WorkflowDefaults == nil || controllerConfig.WorkflowDefaults.PodPriorityClassName == "" {
+		return nil
+	}
+	name := controllerConfig.WorkflowDefaults.PodPriorityClassName
+	if err := r.Get(ctx, client.ObjectKey{Name: name}, &schedulingv1.PriorityClass{}); err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("workflow pod priority class %q not found", name)
+		}
+		return err
+	}
+	return nil
+}
+
+// validateWorkflowServiceAccount checks that the default workflow
+// ServiceAccount exists in the namespace of the instance.
+func (r *ArgoWorkFlowReconciler) validateWorkflowServiceAccount(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	controllerConfig := instance.Spec.ControllerConfig
+	if controllerConfig == nil || controllerConfig.WorkflowDefaults == nil || controllerConfig.WorkflowDefaults.ServiceAccountName == "" {
+		return nil
+	}
+	name := controllerConfig.WorkflowDefaults.ServiceAccountName
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: name}, &corev1.ServiceAccount{}); err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("workflow service account %q not found", name)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkWorkflowVolumeConfigMaps warns about ConfigMaps of the workflow volumes
+// that are missing in the namespace of the instance. Workflows may run in
+// other namespaces as well, so a missing ConfigMap does not fail the
+// reconcile.
+func (r *ArgoWorkFlowReconciler) checkWorkflowVolumeConfigMaps(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	controllerConfig := instance.Spec.ControllerConfig
+	if controllerConfig == nil || controllerConfig.WorkflowDefaults == nil {
+		return nil
+	}
+	for _, volume := range controllerConfig.WorkflowDefaults.Volumes {
+		source := volume.ConfigMap
+		if source == nil || (source.Optional != nil && *source.Optional) {
+			continue
+		}
+		err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: source.Name}, &corev1.ConfigMap{})
+		if errors.IsNotFound(err) {
+			r.Recorder.Eventf(instance, corev1.EventTypeWarning, "WorkflowVolumeMissing",
+				"ConfigMap %s of workflow volume %q not found, workflow pods mounting it will not start", source.Name, volume.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ArgoWorkFlowReconciler) checkSecretKey(ctx context.Context, namespace string, selector *corev1.SecretKeySelector) error {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: selector.Name}, secret); err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("artifact secret %q not found", selector.Name)
+		}
+		return err
+	}
+	if _, ok := secret.Data[selector.Key]; !ok {
+		return fmt.Errorf("artifact secret %q has no key %q", selector.Name, selector.Key)
+	}
+	return nil
+}
+
+// handleImmutableConfigMap reports a controller ConfigMap marked immutable,
+// which can no longer be updated, in the ConfigMapImmutable condition. With
+// the force-recreate annotation on the instance the ConfigMap is deleted so
+// it gets created again.
+func (r *ArgoWorkFlowReconciler) handleImmutableConfigMap(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow, desired *corev1.ConfigMap) error {
+	current := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), current); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if current.Immutable == nil || !*current.Immutable {
+		apimeta.RemoveStatusCondition(&instance.Status.Conditions, stackv1alpha1.ConditionTypeImmutable)
+		return nil
+	}
+
+	if _, force := instance.Annotations[forceRecreateAnnotation]; force {
+		r.Log.Info("Recreating immutable configmap", "Name", current.Name)
+		if err := DeleteIfExists(ctx, r.Client, current); err != nil {
+			r.Log.Error(err, "Failed to delete immutable configmap")
+			return err
+		}
+		apimeta.RemoveStatusCondition(&instance.Status.Conditions, stackv1alpha1.ConditionTypeImmutable)
+		return nil
+	}
+
+	err := fmt.Errorf("configmap %q is immutable and cannot be updated, annotate the ArgoWorkFlow with %q to recreate it", current.Name, forceRecreateAnnotation)
+	instance.SetStatusCondition(metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypeImmutable,
+		Status:             metav1.ConditionTrue,
+		Reason:             stackv1alpha1.ConditionReasonImmutable,
+		Message:            err.Error(),
+		ObservedGeneration: instance.GetGeneration(),
+	})
+	r.Recorder.Event(instance, corev1.EventTypeWarning, stackv1alpha1.ConditionReasonImmutable, err.Error())
+	return err
+}
+
+// deleteStaleConfigMap deletes the named ConfigMap if it is controlled by the
+// instance. ConfigMaps owned by anyone else are left alone.
+func (r *ArgoWorkFlowReconciler) deleteStaleConfigMap(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow, name string) error {
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: name}, configMap); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(configMap, instance) {
+		return nil
+	}
+	r.Log.Info("Deleting stale configmap", "Name", name)
+	return DeleteIfExists(ctx, r.Client, configMap)
+}
+
+// mergeForeignConfigMapKeys records the operator owned keys of the desired
+// ConfigMap in the managed-keys annotation and copies every other key found
+// on the live ConfigMap into it, so keys added by other tools survive the
+// update. Keys the operator owned before but no longer renders are dropped.
+func (r *ArgoWorkFlowReconciler) mergeForeignConfigMapKeys(ctx context.Context, obj *corev1.ConfigMap) error {
+	managed := make([]string, 0, len(obj.Data))
+	for key := range obj.Data {
+		managed = append(managed, key)
+	}
+	sort.Strings(managed)
+	if obj.Annotations == nil {
+		obj.Annotations = map[string]string{}
+	}
+	obj.Annotations[managedKeysAnnotation] = strings.Join(managed, ",")
+
+	current := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	previouslyManaged := map[string]bool{}
+	for _, key := range strings.Split(current.Annotations[managedKeysAnnotation], ",") {
+		previouslyManaged[key] = true
+	}
+	for key, value := range current.Data {
+		if _, owned := obj.Data[key]; owned || previouslyManaged[key] {
+			continue
+		}
+		obj.Data[key] = value
+	}
+	return nil
+}
+
+// cleanupConfigMapMirrors deletes backup ConfigMaps of the instance that no
+// longer match Spec.ConfigMap.MirrorName, e.g. after the mirror was disabled
+// or renamed.
+func (r *ArgoWorkFlowReconciler) cleanupConfigMapMirrors(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	mirrorName := ""
+	if instance.Spec.ConfigMap != nil && existingConfigMapName(instance) == "" {
+		mirrorName = instance.Spec.ConfigMap.MirrorName
+	}
+
+	mirrors := &corev1.ConfigMapList{}
+	if err := r.List(ctx, mirrors, client.InNamespace(instance.Namespace), client.MatchingLabels{backupLabel: "true"}); err != nil {
+		return err
+	}
+	for i := range mirrors.Items {
+		mirror := &mirrors.Items[i]
+		if mirror.Name == mirrorName || !metav1.IsControlledBy(mirror, instance) {
+			continue
+		}
+		r.Log.Info("Deleting stale configmap mirror", "Name", mirror.Name)
+		if err := DeleteIfExists(ctx, r.Client, mirror); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// makeConfigMapMirror returns an immutable backup copy of the controller
+// ConfigMap, kept under Spec.ConfigMap.MirrorName and labeled as a backup.
+func (r *ArgoWorkFlowReconciler) makeConfigMapMirror(instance *stackv1alpha1.ArgoWorkFlow, configMap *corev1.ConfigMap, schema *runtime.Scheme) *corev1.ConfigMap {
+	labels := make(map[string]string, len(configMap.Labels)+1)
+	for key, value := range configMap.Labels {
+		labels[key] = value
+	}
+	labels[backupLabel] = "true"
+
+	immutable := true
+	mirror := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      instance.Spec.ConfigMap.MirrorName,
+			Namespace: instance.Namespace,
+			Labels:    labels,
+		},
+		Data:      configMap.Data,
+		Immutable: &immutable,
+	}
+
+	err := ctrl.SetControllerReference(instance, mirror, schema)
+	if err != nil {
+		r.Log.Error(err, "Failed to set controller reference for configmap mirror")
+		return nil
+	}
+	return mirror
 }
@@ -0,0 +1,2309 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/yaml"
+)
+
+// operatorRules returns the rules of the generated operator ClusterRole.
+func operatorRules(t *testing.T) []rbacv1.PolicyRule {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "config", "rbac", "role.yaml"))
+	if err != nil {
+		t.Fatalf("read role.yaml: %v", err)
+	}
+	role := &rbacv1.ClusterRole{}
+	if err := yaml.Unmarshal(data, role); err != nil {
+		t.Fatalf("parse role.yaml: %v", err)
+	}
+	return role.Rules
+}
+
+func matches(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == rbacv1.ResourceAll {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether the rules grant verb on the named resource. An empty
+// name asks for the verb on every object of the resource.
+func allows(rules []rbacv1.PolicyRule, group, resource, name, verb string) bool {
+	for _, rule := range rules {
+		if !matches(rule.APIGroups, group) || !matches(rule.Resources, resource) || !matches(rule.Verbs, verb) {
+			continue
+		}
+		if len(rule.ResourceNames) == 0 || (name != "" && matches(rule.ResourceNames, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOperatorCanRenewLease(t *testing.T) {
+	rules := operatorRules(t)
+	tests := []struct {
+		name       string
+		instanceID string
+		want       string
+	}{
+		{name: "default", want: "workflow-controller-ns-argo"},
+		{name: "instance id", instanceID: "team-a", want: "workflow-controller-team-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			if tt.instanceID != "" {
+				instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{InstanceID: tt.instanceID}
+			}
+			name := leaseName(instance)
+			if name != tt.want {
+				t.Fatalf("leaseName() = %q, want %q", name, tt.want)
+			}
+			for _, verb := range []string{"get", "create", "update"} {
+				if !allows(rules, "coordination.k8s.io", "leases", name, verb) {
+					t.Errorf("operator cannot %s lease %q", verb, name)
+				}
+			}
+		})
+	}
+}
+
+func TestOperatorHoldsClusterRoleRules(t *testing.T) {
+	// The API server only lets the operator create a ClusterRole, and bind
+	// it, when the operator holds every permission in it.
+	rules := operatorRules(t)
+	for _, rule := range clusterRoleRules {
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				for _, verb := range rule.Verbs {
+					if !allows(rules, group, resource, "", verb) {
+						t.Errorf("operator cannot %s %s in group %q", verb, resource, group)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestClusterRBACName(t *testing.T) {
+	a := newTestArgoWorkFlow("team-a", "argo")
+	b := newTestArgoWorkFlow("team-b", "argo")
+	if got := clusterRBACName(a); got != "team-a-argo-controller" {
+		t.Errorf("clusterRBACName() = %q, want %q", got, "team-a-argo-controller")
+	}
+	if clusterRBACName(a) == clusterRBACName(b) {
+		t.Errorf("instances of the same name in different namespaces share %q", clusterRBACName(a))
+	}
+}
+
+func TestReconcileClusterRoleBinding(t *testing.T) {
+	tests := []struct {
+		name              string
+		createClusterRole bool
+		wantRoleRef       string
+	}{
+		{name: "create", createClusterRole: true, wantRoleRef: "ns-argo-controller"},
+		{name: "skip", createClusterRole: false, wantRoleRef: "manager-role"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.RBAC = &stackv1alpha1.RBACSpec{CreateClusterRole: tt.createClusterRole}
+			r := newTestReconciler(t, instance)
+
+			if err := r.reconcileClusterRoleBinding(ctx, instance); err != nil {
+				t.Fatalf("reconcileClusterRoleBinding() error = %v", err)
+			}
+
+			binding := &rbacv1.ClusterRoleBinding{}
+			if err := r.Get(ctx, client.ObjectKeyFromObject(r.makeClusterRoleBinding(instance, r.Scheme)), binding); err != nil {
+				t.Fatalf("get ClusterRoleBinding: %v", err)
+			}
+			if binding.RoleRef.Name != tt.wantRoleRef {
+				t.Errorf("roleRef = %q, want %q", binding.RoleRef.Name, tt.wantRoleRef)
+			}
+
+			clusterRole := &rbacv1.ClusterRole{}
+			err := r.Get(ctx, client.ObjectKey{Name: "ns-argo-controller"}, clusterRole)
+			if tt.createClusterRole {
+				if err != nil {
+					t.Fatalf("get ClusterRole: %v", err)
+				}
+				if len(clusterRole.Rules) != len(clusterRoleRules) {
+					t.Errorf("ClusterRole has %d rules, want %d", len(clusterRole.Rules), len(clusterRoleRules))
+				}
+			} else if !errors.IsNotFound(err) {
+				t.Errorf("get ClusterRole error = %v, want NotFound", err)
+			}
+		})
+	}
+}
+
+func TestReconcileClusterRoleBindingDeletesLegacyBinding(t *testing.T) {
+	legacy := func(namespace string) *rbacv1.ClusterRoleBinding {
+		return &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "argo-controller"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: "argo-controller"},
+			Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: "argo-controller", Namespace: namespace}},
+		}
+	}
+	tests := []struct {
+		name       string
+		legacy     *rbacv1.ClusterRoleBinding
+		wantLegacy bool
+	}{
+		{name: "own binding", legacy: legacy("ns"), wantLegacy: false},
+		{name: "binding of another namespace", legacy: legacy("other"), wantLegacy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			legacyRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "argo-controller"}}
+			r := newTestReconciler(t, instance, tt.legacy, legacyRole)
+
+			if err := r.reconcileClusterRoleBinding(ctx, instance); err != nil {
+				t.Fatalf("reconcileClusterRoleBinding() error = %v", err)
+			}
+
+			for _, obj := range []client.Object{&rbacv1.ClusterRoleBinding{}, &rbacv1.ClusterRole{}} {
+				err := r.Get(ctx, client.ObjectKey{Name: "argo-controller"}, obj)
+				if tt.wantLegacy && err != nil {
+					t.Errorf("legacy %T was deleted: %v", obj, err)
+				}
+				if !tt.wantLegacy && !errors.IsNotFound(err) {
+					t.Errorf("legacy %T error = %v, want NotFound", obj, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateExtraRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    rbacv1.PolicyRule
+		wantErr bool
+	}{
+		{
+			name: "workflow objects",
+			rule: rbacv1.PolicyRule{APIGroups: []string{"argoproj.io"}, Resources: []string{"workflows", "workflowtaskresults"}, Verbs: []string{"create", "patch"}},
+		},
+		{
+			name: "core resources",
+			rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}, Verbs: []string{"get"}},
+		},
+		{
+			name:    "secrets",
+			rule:    rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+			wantErr: true,
+		},
+		{
+			name:    "rbac objects",
+			rule:    rbacv1.PolicyRule{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"roles"}, Verbs: []string{"create"}},
+			wantErr: true,
+		},
+		{
+			name:    "wildcard group",
+			rule:    rbacv1.PolicyRule{APIGroups: []string{"*"}, Resources: []string{"pods"}, Verbs: []string{"get"}},
+			wantErr: true,
+		},
+		{
+			name:    "wildcard resource",
+			rule:    rbacv1.PolicyRule{APIGroups: []string{"argoproj.io"}, Resources: []string{"*"}, Verbs: []string{"get"}},
+			wantErr: true,
+		},
+		{
+			name:    "no api groups",
+			rule:    rbacv1.PolicyRule{Resources: []string{"pods"}, Verbs: []string{"get"}},
+			wantErr: true,
+		},
+		{
+			name:    "no verbs",
+			rule:    rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}},
+			wantErr: true,
+		},
+		{
+			name:    "non-resource urls",
+			rule:    rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}, NonResourceURLs: []string{"/metrics"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateExtraRules([]rbacv1.PolicyRule{tt.rule})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateExtraRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReconcileWorkflowRoleRejectsRule(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	instance.Spec.RBAC = &stackv1alpha1.RBACSpec{ExtraRules: []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}},
+	}}
+	r := newTestReconciler(t, instance)
+
+	if err := r.reconcileWorkflowRole(ctx, instance); err == nil {
+		t.Fatal("reconcileWorkflowRole() error = nil, want the rule to be rejected")
+	}
+	err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo-workflow"}, &rbacv1.Role{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("get Role error = %v, want NotFound", err)
+	}
+}
+
+func TestReconcileWorkflowRole(t *testing.T) {
+	extraRules := []rbacv1.PolicyRule{
+		{APIGroups: []string{"argoproj.io"}, Resources: []string{"workflows", "workflowtemplates"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}},
+	}
+	tests := []struct {
+		name  string
+		rules []rbacv1.PolicyRule
+	}{
+		{name: "agent rules only"},
+		{name: "extra rules", rules: extraRules},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			if tt.rules != nil {
+				instance.Spec.RBAC = &stackv1alpha1.RBACSpec{ExtraRules: tt.rules}
+			}
+			r := newTestReconciler(t, instance)
+
+			if err := r.reconcileWorkflowRole(ctx, instance); err != nil {
+				t.Fatalf("reconcileWorkflowRole() error = %v", err)
+			}
+			role := &rbacv1.Role{}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo-workflow"}, role); err != nil {
+				t.Fatalf("get Role: %v", err)
+			}
+			for _, access := range []struct{ resource, verb string }{
+				{"workflowtasksets", "list"},
+				{"workflowtasksets", "watch"},
+				{"workflowtasksets/status", "patch"},
+			} {
+				if !allows(role.Rules, "argoproj.io", access.resource, "", access.verb) {
+					t.Errorf("workflow Role cannot %s %s: %+v", access.verb, access.resource, role.Rules)
+				}
+			}
+			want := append(append([]rbacv1.PolicyRule{}, workflowAgentRules...), tt.rules...)
+			if !apiequality.Semantic.DeepEqual(role.Rules, want) {
+				t.Errorf("Role.Rules = %+v, want %+v", role.Rules, want)
+			}
+			binding := &rbacv1.RoleBinding{}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo-workflow"}, binding); err != nil {
+				t.Fatalf("get RoleBinding: %v", err)
+			}
+			if binding.RoleRef.Name != role.Name || len(binding.Subjects) != 1 || binding.Subjects[0].Name != workflowServiceAccountName(instance) {
+				t.Errorf("RoleBinding = %+v, want %s bound to the workflow ServiceAccount", binding, role.Name)
+			}
+		})
+	}
+}
+
+func TestMergeForeignConfigMapKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		live        *corev1.ConfigMap
+		wantData    map[string]string
+		wantManaged string
+	}{
+		{
+			name:        "no live configmap",
+			wantData:    map[string]string{"config": "rendered", "parallelism": "10"},
+			wantManaged: "config,parallelism",
+		},
+		{
+			name: "foreign key kept",
+			live: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{managedKeysAnnotation: "config,parallelism"},
+				},
+				Data: map[string]string{"config": "old", "parallelism": "5", "sso": "added by another tool"},
+			},
+			wantData:    map[string]string{"config": "rendered", "parallelism": "10", "sso": "added by another tool"},
+			wantManaged: "config,parallelism",
+		},
+		{
+			name: "previously managed key dropped",
+			live: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{managedKeysAnnotation: "config,metricsConfig,parallelism"},
+				},
+				Data: map[string]string{"config": "old", "metricsConfig": "old", "parallelism": "5"},
+			},
+			wantData:    map[string]string{"config": "rendered", "parallelism": "10"},
+			wantManaged: "config,parallelism",
+		},
+		{
+			name: "live configmap without annotation",
+			live: &corev1.ConfigMap{
+				Data: map[string]string{"config": "old", "sso": "manual"},
+			},
+			wantData:    map[string]string{"config": "rendered", "parallelism": "10", "sso": "manual"},
+			wantManaged: "config,parallelism",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var objs []client.Object
+			if tt.live != nil {
+				tt.live.Name, tt.live.Namespace = "argo", "ns"
+				objs = append(objs, tt.live)
+			}
+			r := newTestReconciler(t, objs...)
+			desired := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "argo", Namespace: "ns"},
+				Data:       map[string]string{"config": "rendered", "parallelism": "10"},
+			}
+
+			if err := r.mergeForeignConfigMapKeys(ctx, desired); err != nil {
+				t.Fatalf("mergeForeignConfigMapKeys() error = %v", err)
+			}
+			if !reflect.DeepEqual(desired.Data, tt.wantData) {
+				t.Errorf("data = %v, want %v", desired.Data, tt.wantData)
+			}
+			if got := desired.Annotations[managedKeysAnnotation]; got != tt.wantManaged {
+				t.Errorf("managed keys = %q, want %q", got, tt.wantManaged)
+			}
+		})
+	}
+}
+
+func TestReconcileConfigMapKeepsForeignKeys(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	r := newTestReconciler(t, instance)
+
+	if err := r.reconcileConfigMap(ctx, instance); err != nil {
+		t.Fatalf("reconcileConfigMap() error = %v", err)
+	}
+	key := client.ObjectKey{Namespace: "ns", Name: generatedConfigMapName(instance)}
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, key, configMap); err != nil {
+		t.Fatal(err)
+	}
+	configMap.Data["sso"] = "added by another tool"
+	if err := r.Update(ctx, configMap); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.reconcileConfigMap(ctx, instance); err != nil {
+		t.Fatalf("second reconcileConfigMap() error = %v", err)
+	}
+	if err := r.Get(ctx, key, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data["sso"] != "added by another tool" {
+		t.Errorf("foreign key was dropped: %v", configMap.Data)
+	}
+	if strings.Contains(configMap.Annotations[managedKeysAnnotation], "sso") {
+		t.Errorf("foreign key recorded as managed: %q", configMap.Annotations[managedKeysAnnotation])
+	}
+}
+
+func TestCheckDeploymentRollout(t *testing.T) {
+	tests := []struct {
+		name          string
+		generation    int64
+		status        appsv1.DeploymentStatus
+		wantRolledOut bool
+		wantStatus    metav1.ConditionStatus
+		wantReason    string
+	}{
+		{
+			name:          "rollout complete",
+			generation:    2,
+			status:        appsv1.DeploymentStatus{ObservedGeneration: 2, UpdatedReplicas: 2},
+			wantRolledOut: true,
+			wantStatus:    metav1.ConditionFalse,
+			wantReason:    stackv1alpha1.ConditionReasonRunning,
+		},
+		{
+			name:       "spec not observed yet",
+			generation: 3,
+			status:     appsv1.DeploymentStatus{ObservedGeneration: 2, UpdatedReplicas: 2},
+			wantStatus: metav1.ConditionTrue,
+			wantReason: stackv1alpha1.ConditionReasonReconcileDeployment,
+		},
+		{
+			name:       "mid rollout",
+			generation: 2,
+			status:     appsv1.DeploymentStatus{ObservedGeneration: 2, UpdatedReplicas: 1, Replicas: 3},
+			wantStatus: metav1.ConditionTrue,
+			wantReason: stackv1alpha1.ConditionReasonReconcileDeployment,
+		},
+		{
+			name:       "progress deadline exceeded",
+			generation: 2,
+			status: appsv1.DeploymentStatus{
+				ObservedGeneration: 2,
+				UpdatedReplicas:    1,
+				Conditions: []appsv1.DeploymentCondition{{
+					Type:    appsv1.DeploymentProgressing,
+					Status:  corev1.ConditionFalse,
+					Reason:  deploymentProgressDeadlineExceeded,
+					Message: "ReplicaSet has timed out progressing.",
+				}},
+			},
+			wantStatus: metav1.ConditionFalse,
+			wantReason: stackv1alpha1.ConditionReasonProgressDeadline,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			replicas := int32(2)
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "argo", Namespace: "ns", Generation: tt.generation},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+				Status:     tt.status,
+			}
+			r := newTestReconciler(t, instance, deployment)
+
+			rolledOut, err := r.checkDeploymentRollout(ctx, instance)
+			if err != nil {
+				t.Fatalf("checkDeploymentRollout() error = %v", err)
+			}
+			if rolledOut != tt.wantRolledOut {
+				t.Errorf("checkDeploymentRollout() = %v, want %v", rolledOut, tt.wantRolledOut)
+			}
+			condition := apimeta.FindStatusCondition(instance.Status.Conditions, stackv1alpha1.ConditionTypeProgressing)
+			if condition == nil {
+				t.Fatal("Progressing condition not set")
+			}
+			if condition.Status != tt.wantStatus || condition.Reason != tt.wantReason {
+				t.Errorf("Progressing = %s/%s, want %s/%s", condition.Status, condition.Reason, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestReconcileRequeuesDuringRollout(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	r := newTestReconciler(t, instance)
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+
+	// The fake client never rolls the Deployment out, so it stays mid rollout.
+	result, err := r.Reconcile(ctx, req)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if result.RequeueAfter <= 0 {
+		t.Errorf("Reconcile() RequeueAfter = %v, want a requeue while the rollout runs", result.RequeueAfter)
+	}
+	stored := &stackv1alpha1.ArgoWorkFlow{}
+	if err := r.Get(ctx, req.NamespacedName, stored); err != nil {
+		t.Fatal(err)
+	}
+	if !apimeta.IsStatusConditionTrue(stored.Status.Conditions, stackv1alpha1.ConditionTypeProgressing) {
+		t.Errorf("Progressing is not True mid rollout: %v", stored.Status.Conditions)
+	}
+}
+
+func TestPruneReplicaSets(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo", Namespace: "ns", UID: "deployment-uid"},
+	}
+	replicaSet := func(name string, revision string, replicas int32, controlled bool) *appsv1.ReplicaSet {
+		rs := &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "ns",
+				Labels:      map[string]string{"app": "argo"},
+				Annotations: map[string]string{deploymentRevisionAnnotation: revision},
+			},
+			Spec: appsv1.ReplicaSetSpec{Replicas: &replicas},
+		}
+		if controlled {
+			rs.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))}
+		}
+		return rs
+	}
+	tests := []struct {
+		name        string
+		limit       *int32
+		replicaSets []*appsv1.ReplicaSet
+		wantDeleted []string
+	}{
+		{
+			name:  "within limit",
+			limit: pointer.Int32(2),
+			replicaSets: []*appsv1.ReplicaSet{
+				replicaSet("rs-1", "1", 0, true),
+				replicaSet("rs-2", "2", 0, true),
+				replicaSet("rs-3", "3", 1, true),
+			},
+		},
+		{
+			name:  "oldest inactive revisions deleted",
+			limit: pointer.Int32(1),
+			replicaSets: []*appsv1.ReplicaSet{
+				replicaSet("rs-1", "1", 0, true),
+				replicaSet("rs-2", "2", 0, true),
+				replicaSet("rs-10", "10", 0, true),
+				replicaSet("rs-11", "11", 2, true),
+			},
+			wantDeleted: []string{"rs-1", "rs-2"},
+		},
+		{
+			name:  "active replicaset never deleted",
+			limit: pointer.Int32(0),
+			replicaSets: []*appsv1.ReplicaSet{
+				replicaSet("rs-1", "1", 1, true),
+				replicaSet("rs-2", "2", 0, true),
+			},
+			wantDeleted: []string{"rs-2"},
+		},
+		{
+			name:  "replicaset still scaling down kept",
+			limit: pointer.Int32(0),
+			replicaSets: []*appsv1.ReplicaSet{
+				func() *appsv1.ReplicaSet {
+					rs := replicaSet("rs-1", "1", 0, true)
+					rs.Status.Replicas = 1
+					return rs
+				}(),
+			},
+		},
+		{
+			name:  "replicaset of another owner kept",
+			limit: pointer.Int32(0),
+			replicaSets: []*appsv1.ReplicaSet{
+				replicaSet("rs-1", "1", 0, false),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.RevisionHistoryLimit = tt.limit
+			objs := []client.Object{instance, deployment.DeepCopy()}
+			for _, rs := range tt.replicaSets {
+				objs = append(objs, rs)
+			}
+			r := newTestReconciler(t, objs...)
+
+			if err := r.pruneReplicaSets(ctx, instance); err != nil {
+				t.Fatalf("pruneReplicaSets() error = %v", err)
+			}
+
+			deleted := map[string]bool{}
+			for _, name := range tt.wantDeleted {
+				deleted[name] = true
+			}
+			for _, rs := range tt.replicaSets {
+				err := r.Get(ctx, client.ObjectKeyFromObject(rs), &appsv1.ReplicaSet{})
+				if deleted[rs.Name] && !errors.IsNotFound(err) {
+					t.Errorf("%s error = %v, want it deleted", rs.Name, err)
+				}
+				if !deleted[rs.Name] && err != nil {
+					t.Errorf("%s was deleted: %v", rs.Name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileDeploymentPodAnnotations(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	instance.Spec.PodAnnotations = map[string]string{
+		"prometheus.io/scrape": "true",
+		configHashAnnotation:   "user value",
+	}
+	r := newTestReconciler(t, instance)
+
+	if err := r.reconcileDeployment(ctx, instance); err != nil {
+		t.Fatalf("reconcileDeployment() error = %v", err)
+	}
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	annotations := deployment.Spec.Template.Annotations
+	if annotations["prometheus.io/scrape"] != "true" {
+		t.Errorf("user pod annotation missing: %v", annotations)
+	}
+	if annotations[configHashAnnotation] != instance.Status.ConfigHash {
+		t.Errorf("%s = %q, want the operator checksum %q", configHashAnnotation, annotations[configHashAnnotation], instance.Status.ConfigHash)
+	}
+
+	// With HotReload the operator sets no checksum, the user value is still
+	// not applied.
+	instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{HotReload: true}
+	if err := r.reconcileDeployment(ctx, instance); err != nil {
+		t.Fatalf("hot reload reconcileDeployment() error = %v", err)
+	}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := deployment.Spec.Template.Annotations[configHashAnnotation]; ok {
+		t.Errorf("%s = %q with HotReload, want it unset", configHashAnnotation, value)
+	}
+	instance.Spec.ControllerConfig = nil
+
+	// An inject annotation set by hand survives the next reconcile.
+	deployment.Spec.Template.Annotations[istioInjectAnnotation] = "false"
+	if err := r.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileDeployment(ctx, instance); err != nil {
+		t.Fatalf("second reconcileDeployment() error = %v", err)
+	}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo"}, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if deployment.Spec.Template.Annotations[istioInjectAnnotation] != "false" {
+		t.Errorf("%s was stripped: %v", istioInjectAnnotation, deployment.Spec.Template.Annotations)
+	}
+}
+
+func TestReconcileConfigMapRename(t *testing.T) {
+	tests := []struct {
+		name            string
+		controlled      bool
+		mutate          func(instance *stackv1alpha1.ArgoWorkFlow)
+		wantOldDeleted  bool
+		wantConfigMap   string
+		wantStatusEmpty bool
+	}{
+		{
+			name:       "renamed",
+			controlled: true,
+			mutate: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.ConfigMap = &stackv1alpha1.ConfigMapSpec{Name: "argo-config"}
+			},
+			wantOldDeleted: true,
+			wantConfigMap:  "argo-config",
+		},
+		{
+			name: "renamed away from a configmap of someone else",
+			mutate: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.ConfigMap = &stackv1alpha1.ConfigMapSpec{Name: "argo-config"}
+			},
+			wantConfigMap: "argo-config",
+		},
+		{
+			name:       "existing configmap referenced",
+			controlled: true,
+			mutate: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{ExistingConfigMap: "shared"}
+			},
+			wantOldDeleted:  true,
+			wantStatusEmpty: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Status.ConfigMapName = "argo-controller"
+			old := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "argo-controller", Namespace: "ns"}}
+			if tt.controlled {
+				old.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(instance, stackv1alpha1.GroupVersion.WithKind("ArgoWorkFlow"))}
+			}
+			shared := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "ns"}}
+			tt.mutate(instance)
+			r := newTestReconciler(t, instance, old, shared)
+
+			if err := r.reconcileConfigMap(ctx, instance); err != nil {
+				t.Fatalf("reconcileConfigMap() error = %v", err)
+			}
+
+			err := r.Get(ctx, client.ObjectKeyFromObject(old), &corev1.ConfigMap{})
+			if tt.wantOldDeleted && !errors.IsNotFound(err) {
+				t.Errorf("old configmap error = %v, want it deleted", err)
+			}
+			if !tt.wantOldDeleted && err != nil {
+				t.Errorf("old configmap was deleted: %v", err)
+			}
+			if tt.wantStatusEmpty {
+				if instance.Status.ConfigMapName != "" {
+					t.Errorf("Status.ConfigMapName = %q, want it cleared", instance.Status.ConfigMapName)
+				}
+				return
+			}
+			if instance.Status.ConfigMapName != tt.wantConfigMap {
+				t.Errorf("Status.ConfigMapName = %q, want %q", instance.Status.ConfigMapName, tt.wantConfigMap)
+			}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: tt.wantConfigMap}, &corev1.ConfigMap{}); err != nil {
+				t.Errorf("renamed configmap: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateArtifactSecrets(t *testing.T) {
+	selector := func(name, key string, optional bool) *corev1.SecretKeySelector {
+		s := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: key}
+		if optional {
+			s.Optional = pointer.Bool(true)
+		}
+		return s
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "s3-creds", Namespace: "ns"},
+		Data:       map[string][]byte{"accessKey": []byte("a"), "secretKey": []byte("s")},
+	}
+	tests := []struct {
+		name          string
+		defaultRepo   string
+		access        *corev1.SecretKeySelector
+		secretKey     *corev1.SecretKeySelector
+		wantErr       string
+		wantCondition metav1.ConditionStatus
+		wantReason    string
+	}{
+		{
+			name: "no default repository",
+		},
+		{
+			name:          "secrets found",
+			defaultRepo:   "main",
+			access:        selector("s3-creds", "accessKey", false),
+			secretKey:     selector("s3-creds", "secretKey", false),
+			wantCondition: metav1.ConditionTrue,
+			wantReason:    stackv1alpha1.ConditionReasonSecretsFound,
+		},
+		{
+			name:          "missing secret",
+			defaultRepo:   "main",
+			access:        selector("other", "accessKey", false),
+			wantErr:       `artifact secret "other" not found`,
+			wantCondition: metav1.ConditionFalse,
+			wantReason:    stackv1alpha1.ConditionReasonSecretMissing,
+		},
+		{
+			name:          "missing key",
+			defaultRepo:   "main",
+			access:        selector("s3-creds", "accessKey", false),
+			secretKey:     selector("s3-creds", "password", false),
+			wantErr:       `artifact secret "s3-creds" has no key "password"`,
+			wantCondition: metav1.ConditionFalse,
+			wantReason:    stackv1alpha1.ConditionReasonSecretMissing,
+		},
+		{
+			name:          "optional secret missing",
+			defaultRepo:   "main",
+			access:        selector("other", "accessKey", true),
+			wantCondition: metav1.ConditionTrue,
+			wantReason:    stackv1alpha1.ConditionReasonSecretsFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.DefaultArtifactRepository = tt.defaultRepo
+			instance.Spec.ArtifactRepositories = map[string]stackv1alpha1.ArtifactRepositorySpec{
+				"main": {S3: &stackv1alpha1.S3ArtifactRepositorySpec{
+					Endpoint:        "minio:9000",
+					Bucket:          "artifacts",
+					AccessKeySecret: tt.access,
+					SecretKeySecret: tt.secretKey,
+				}},
+			}
+			r := newTestReconciler(t, instance, secret)
+
+			err := r.validateArtifactSecrets(ctx, instance)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("validateArtifactSecrets() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("validateArtifactSecrets() error = %v, want %q", err, tt.wantErr)
+			}
+
+			condition := apimeta.FindStatusCondition(instance.Status.Conditions, stackv1alpha1.ConditionTypeArtifacts)
+			if tt.wantCondition == "" {
+				if condition != nil {
+					t.Errorf("unexpected %s condition: %v", stackv1alpha1.ConditionTypeArtifacts, condition)
+				}
+				return
+			}
+			if condition == nil || condition.Status != tt.wantCondition || condition.Reason != tt.wantReason {
+				t.Errorf("%s condition = %v, want %s/%s", stackv1alpha1.ConditionTypeArtifacts, condition, tt.wantCondition, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestReconcileConfigMapMissingArtifactSecret(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	instance.Spec.DefaultArtifactRepository = "main"
+	instance.Spec.ArtifactRepositories = map[string]stackv1alpha1.ArtifactRepositorySpec{
+		"main": {S3: &stackv1alpha1.S3ArtifactRepositorySpec{
+			Endpoint:        "minio:9000",
+			Bucket:          "artifacts",
+			AccessKeySecret: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "s3-creds"}, Key: "accessKey"},
+		}},
+	}
+	r := newTestReconciler(t, instance)
+
+	if err := r.reconcileConfigMap(ctx, instance); err == nil {
+		t.Fatal("reconcileConfigMap() error = nil, want the missing secret reported")
+	}
+	err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: generatedConfigMapName(instance)}, &corev1.ConfigMap{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("configmap error = %v, want it not written", err)
+	}
+}
+
+func TestMakeDeploymentRevisionHistoryLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit *int32
+		want  int32
+	}{
+		{name: "default", want: 10},
+		{name: "set", limit: pointer.Int32(3), want: 3},
+		{name: "zero", limit: pointer.Int32(0), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.RevisionHistoryLimit = tt.limit
+			r := newTestReconciler(t)
+
+			got := r.makeDeployment(instance, r.Scheme).Spec.RevisionHistoryLimit
+			if got == nil || *got != tt.want {
+				t.Errorf("RevisionHistoryLimit = %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeDeploymentStrategy(t *testing.T) {
+	rollingUpdate := &appsv1.DeploymentStrategy{Type: appsv1.RollingUpdateDeploymentStrategyType}
+	tests := []struct {
+		name           string
+		replicas       int32
+		leaderElection *bool
+		strategy       *appsv1.DeploymentStrategy
+		want           appsv1.DeploymentStrategyType
+		wantEvent      bool
+	}{
+		{name: "single leader elected replica", replicas: 1, want: appsv1.RecreateDeploymentStrategyType, wantEvent: true},
+		{name: "leader election disabled", replicas: 1, leaderElection: pointer.Bool(false), want: appsv1.RollingUpdateDeploymentStrategyType},
+		{name: "several replicas", replicas: 2, want: appsv1.RollingUpdateDeploymentStrategyType},
+		{name: "explicit strategy wins", replicas: 1, strategy: rollingUpdate, want: appsv1.RollingUpdateDeploymentStrategyType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.Replicas = tt.replicas
+			instance.Spec.Strategy = tt.strategy
+			if tt.leaderElection != nil {
+				instance.Spec.LeaderElection = &stackv1alpha1.LeaderElectionSpec{Enabled: tt.leaderElection}
+			}
+			r := newTestReconciler(t, instance)
+
+			if got := makeDeploymentStrategy(instance).Type; got != tt.want {
+				t.Errorf("makeDeploymentStrategy() = %s, want %s", got, tt.want)
+			}
+			if err := r.reconcileDeployment(ctx, instance); err != nil {
+				t.Fatalf("reconcileDeployment() error = %v", err)
+			}
+			if gotEvent := recordedEvent(r, "RecreateStrategySelected"); gotEvent != tt.wantEvent {
+				t.Errorf("RecreateStrategySelected event = %v, want %v", gotEvent, tt.wantEvent)
+			}
+
+			// The event is only emitted while switching to Recreate.
+			if err := r.reconcileDeployment(ctx, instance); err != nil {
+				t.Fatalf("second reconcileDeployment() error = %v", err)
+			}
+			if recordedEvent(r, "RecreateStrategySelected") {
+				t.Error("RecreateStrategySelected event repeated once the strategy is applied")
+			}
+		})
+	}
+}
+
+func TestMakeControllerResources(t *testing.T) {
+	explicit := makeResourceRequirements("1", "1Gi", "4", "4Gi")
+	tests := []struct {
+		name      string
+		profile   string
+		resources *corev1.ResourceRequirements
+		want      corev1.ResourceRequirements
+	}{
+		{name: "none", want: corev1.ResourceRequirements{}},
+		{name: "small", profile: "small", want: makeResourceRequirements("100m", "128Mi", "500m", "512Mi")},
+		{name: "medium", profile: "medium", want: makeResourceRequirements("250m", "256Mi", "1", "1Gi")},
+		{name: "large", profile: "large", want: makeResourceRequirements("500m", "512Mi", "2", "2Gi")},
+		{name: "explicit resources win", profile: "large", resources: &explicit, want: explicit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.ResourceProfile = tt.profile
+			instance.Spec.Resources = tt.resources
+			r := newTestReconciler(t, instance)
+
+			deployment := r.makeDeployment(instance, r.Scheme)
+			got := deployment.Spec.Template.Spec.Containers[0].Resources
+			if !apiequality.Semantic.DeepEqual(got, tt.want) {
+				t.Errorf("controller resources = %v, want %v", got, tt.want)
+			}
+
+			// The presets are shared, changing the rendered copy must not
+			// leak into the next instance.
+			if got.Limits != nil {
+				got.Limits[corev1.ResourceCPU] = resource.MustParse("64")
+			}
+			if tt.resources == nil && !apiequality.Semantic.DeepEqual(makeControllerResources(instance), tt.want) {
+				t.Errorf("resource profile %q was modified through the Deployment", tt.profile)
+			}
+		})
+	}
+}
+
+func TestControllerCommandOverride(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     []string
+		args        []string
+		wantCommand []string
+		wantArgs    []string
+		wantEvent   bool
+	}{
+		{
+			name: "no override",
+		},
+		{
+			name:        "command only",
+			command:     []string{"/bin/workflow-controller-debug"},
+			wantCommand: []string{"/bin/workflow-controller-debug"},
+			wantEvent:   true,
+		},
+		{
+			name:      "args only",
+			args:      []string{"--loglevel", "debug"},
+			wantArgs:  []string{"--loglevel", "debug"},
+			wantEvent: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			if tt.command != nil || tt.args != nil {
+				instance.Spec.Controller = &stackv1alpha1.ControllerSpec{Command: tt.command, Args: tt.args}
+			}
+			r := newTestReconciler(t, instance)
+
+			if err := r.reconcileDeployment(ctx, instance); err != nil {
+				t.Fatalf("reconcileDeployment() error = %v", err)
+			}
+			deployment := &appsv1.Deployment{}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo"}, deployment); err != nil {
+				t.Fatal(err)
+			}
+			container := deployment.Spec.Template.Spec.Containers[0]
+
+			// Without an override each part falls back to the operator
+			// managed entrypoint and arguments.
+			wantArgs := tt.wantArgs
+			if wantArgs == nil {
+				wantArgs = makeControllerArgs(instance)
+			}
+			if !reflect.DeepEqual(container.Command, tt.wantCommand) {
+				t.Errorf("command = %q, want %q", container.Command, tt.wantCommand)
+			}
+			if !reflect.DeepEqual(container.Args, wantArgs) {
+				t.Errorf("args = %q, want %q", container.Args, wantArgs)
+			}
+
+			if gotEvent := recordedEvent(r, "CommandOverridden"); gotEvent != tt.wantEvent {
+				t.Errorf("CommandOverridden event = %v, want %v", gotEvent, tt.wantEvent)
+			}
+
+			// The warning is only repeated when the override changes.
+			if err := r.reconcileDeployment(ctx, instance); err != nil {
+				t.Fatalf("second reconcileDeployment() error = %v", err)
+			}
+			if recordedEvent(r, "CommandOverridden") {
+				t.Error("CommandOverridden event repeated for an applied override")
+			}
+		})
+	}
+}
+
+func TestHandleImmutableConfigMap(t *testing.T) {
+	tests := []struct {
+		name          string
+		immutable     *bool
+		force         bool
+		wantErr       bool
+		wantDeleted   bool
+		wantCondition bool
+	}{
+		{name: "mutable configmap", immutable: pointer.Bool(false)},
+		{name: "immutable unset"},
+		{name: "immutable", immutable: pointer.Bool(true), wantErr: true, wantCondition: true},
+		{name: "immutable with force recreate", immutable: pointer.Bool(true), force: true, wantDeleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			if tt.force {
+				instance.Annotations = map[string]string{forceRecreateAnnotation: ""}
+			}
+			// A condition left from an earlier pass is cleared once resolved.
+			instance.SetStatusCondition(metav1.Condition{
+				Type:   stackv1alpha1.ConditionTypeImmutable,
+				Status: metav1.ConditionTrue,
+				Reason: stackv1alpha1.ConditionReasonImmutable,
+			})
+			live := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "argo-controller", Namespace: "ns"},
+				Data:       map[string]string{"config": "old"},
+				Immutable:  tt.immutable,
+			}
+			r := newTestReconciler(t, instance, live)
+			desired := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "argo-controller", Namespace: "ns"}}
+
+			err := r.handleImmutableConfigMap(ctx, instance, desired)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handleImmutableConfigMap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			getErr := r.Get(ctx, client.ObjectKeyFromObject(live), &corev1.ConfigMap{})
+			if tt.wantDeleted && !errors.IsNotFound(getErr) {
+				t.Errorf("configmap error = %v, want it deleted", getErr)
+			}
+			if !tt.wantDeleted && getErr != nil {
+				t.Errorf("configmap was deleted: %v", getErr)
+			}
+			hasCondition := apimeta.IsStatusConditionTrue(instance.Status.Conditions, stackv1alpha1.ConditionTypeImmutable)
+			if hasCondition != tt.wantCondition {
+				t.Errorf("%s condition = %v, want %v", stackv1alpha1.ConditionTypeImmutable, hasCondition, tt.wantCondition)
+			}
+			if gotEvent := recordedEvent(r, stackv1alpha1.ConditionReasonImmutable); gotEvent != tt.wantCondition {
+				t.Errorf("%s event = %v, want %v", stackv1alpha1.ConditionReasonImmutable, gotEvent, tt.wantCondition)
+			}
+		})
+	}
+}
+
+func TestReconcileConfigMapRecreatesImmutable(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	instance.Annotations = map[string]string{forceRecreateAnnotation: ""}
+	live := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: generatedConfigMapName(instance), Namespace: "ns"},
+		Data:       map[string]string{"config": "old"},
+		Immutable:  pointer.Bool(true),
+	}
+	r := newTestReconciler(t, instance, live)
+
+	if err := r.reconcileConfigMap(ctx, instance); err != nil {
+		t.Fatalf("reconcileConfigMap() error = %v", err)
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(live), configMap); err != nil {
+		t.Fatalf("configmap was not recreated: %v", err)
+	}
+	if configMap.Data["config"] == "old" {
+		t.Errorf("configmap still holds the old config")
+	}
+}
+
+func TestReadyConfigPods(t *testing.T) {
+	now := metav1.Now()
+	pod := func(name, hash string, ready bool, mutate func(pod *corev1.Pod)) *corev1.Pod {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: map[string]string{"app": "argo"}},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}},
+		}
+		if hash != "" {
+			p.Annotations = map[string]string{configHashAnnotation: hash}
+		}
+		if mutate != nil {
+			mutate(p)
+		}
+		return p
+	}
+	terminating := func(p *corev1.Pod) {
+		p.DeletionTimestamp = &now
+		p.Finalizers = []string{"test"}
+	}
+	otherInstance := func(p *corev1.Pod) { p.Labels = map[string]string{"app": "other"} }
+	tests := []struct {
+		name      string
+		hotReload bool
+		pods      []*corev1.Pod
+		want      int32
+	}{
+		{
+			name: "mixed pods",
+			pods: []*corev1.Pod{
+				pod("current-ready", "new", true, nil),
+				pod("current-not-ready", "new", false, nil),
+				pod("old-ready", "old", true, nil),
+				pod("current-terminating", "new", true, terminating),
+				pod("other-instance", "new", true, otherInstance),
+			},
+			want: 1,
+		},
+		{
+			name: "rollout to the new config finished",
+			pods: []*corev1.Pod{
+				pod("a", "new", true, nil),
+				pod("b", "new", true, nil),
+			},
+			want: 2,
+		},
+		{
+			name:      "hot reload ignores the checksum",
+			hotReload: true,
+			pods: []*corev1.Pod{
+				pod("a", "", true, nil),
+				pod("b", "", false, nil),
+			},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Status.ConfigHash = "new"
+			if tt.hotReload {
+				instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{HotReload: true}
+			}
+			objs := []client.Object{instance}
+			for _, p := range tt.pods {
+				objs = append(objs, p)
+			}
+			r := newTestReconciler(t, objs...)
+
+			got, err := r.readyConfigPods(ctx, instance)
+			if err != nil {
+				t.Fatalf("readyConfigPods() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("readyConfigPods() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// argValue returns the value following flag in args.
+func argValue(args []string, flag string) (string, bool) {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+func TestControllerLogLevel(t *testing.T) {
+	tests := []struct {
+		name            string
+		logLevel        string
+		controllerLevel string
+		want            string
+	}{
+		{name: "default", want: "info"},
+		{name: "top-level", logLevel: "warn", want: "warn"},
+		{name: "controller", controllerLevel: "debug", want: "debug"},
+		{name: "controller overrides top-level", logLevel: "error", controllerLevel: "debug", want: "debug"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.LogLevel = tt.logLevel
+			if tt.controllerLevel != "" {
+				instance.Spec.Controller = &stackv1alpha1.ControllerSpec{LogLevel: tt.controllerLevel}
+			}
+			if got, _ := argValue(makeControllerArgs(instance), "--loglevel"); got != tt.want {
+				t.Errorf("--loglevel = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsQuotaExceeded(t *testing.T) {
+	pods := corev1.Resource("pods")
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "quota exceeded", err: errors.NewForbidden(pods, "argo", fmt.Errorf("exceeded quota: compute, requested: pods=1, used: pods=10, limited: pods=10")), want: true},
+		{name: "wrapped quota error", err: fmt.Errorf("create deployment: %w", errors.NewForbidden(pods, "argo", fmt.Errorf("exceeded quota: compute"))), want: true},
+		{name: "other forbidden error", err: errors.NewForbidden(pods, "argo", fmt.Errorf("not allowed by policy"))},
+		{name: "not forbidden", err: fmt.Errorf("exceeded quota")},
+		{name: "nil", err: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isQuotaExceeded(tt.err); got != tt.want {
+				t.Errorf("isQuotaExceeded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPodQuota(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []appsv1.DeploymentCondition
+		want       metav1.ConditionStatus
+	}{
+		{name: "no conditions", want: metav1.ConditionFalse},
+		{
+			name: "pods rejected by the quota",
+			conditions: []appsv1.DeploymentCondition{{
+				Type:    appsv1.DeploymentReplicaFailure,
+				Status:  corev1.ConditionTrue,
+				Message: `pods "argo-abc" is forbidden: exceeded quota: compute`,
+			}},
+			want: metav1.ConditionTrue,
+		},
+		{
+			name: "other replica failure",
+			conditions: []appsv1.DeploymentCondition{{
+				Type:    appsv1.DeploymentReplicaFailure,
+				Status:  corev1.ConditionTrue,
+				Message: `pods "argo-abc" is forbidden: unable to validate against any pod security policy`,
+			}},
+			want: metav1.ConditionFalse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			checkPodQuota(instance, &appsv1.Deployment{Status: appsv1.DeploymentStatus{Conditions: tt.conditions}})
+			condition := apimeta.FindStatusCondition(instance.Status.Conditions, stackv1alpha1.ConditionTypeQuota)
+			if condition == nil || condition.Status != tt.want {
+				t.Errorf("%s condition = %v, want %s", stackv1alpha1.ConditionTypeQuota, condition, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileDeploymentConfigHash(t *testing.T) {
+	tests := []struct {
+		name           string
+		hotReload      bool
+		wantAnnotation bool
+	}{
+		{name: "restart on config change", wantAnnotation: true},
+		{name: "hot reload", hotReload: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{HotReload: tt.hotReload}
+			r := newTestReconciler(t, instance)
+
+			podTemplateHash := func() (string, bool) {
+				t.Helper()
+				if err := r.reconcileDeployment(ctx, instance); err != nil {
+					t.Fatalf("reconcileDeployment() error = %v", err)
+				}
+				deployment := &appsv1.Deployment{}
+				if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo"}, deployment); err != nil {
+					t.Fatal(err)
+				}
+				hash, ok := deployment.Spec.Template.Annotations[configHashAnnotation]
+				return hash, ok
+			}
+
+			hash, ok := podTemplateHash()
+			if ok != tt.wantAnnotation {
+				t.Fatalf("%s set = %v, want %v", configHashAnnotation, ok, tt.wantAnnotation)
+			}
+			if instance.Status.ConfigHash == "" {
+				t.Error("Status.ConfigHash is empty, want it recorded in both modes")
+			}
+			if ok && hash != instance.Status.ConfigHash {
+				t.Errorf("%s = %q, want Status.ConfigHash %q", configHashAnnotation, hash, instance.Status.ConfigHash)
+			}
+
+			// A config change only rolls the pods without hot reload.
+			previous := instance.Status.ConfigHash
+			instance.Spec.ControllerConfig.InstanceID = "team-a"
+			hash, ok = podTemplateHash()
+			if instance.Status.ConfigHash == previous {
+				t.Errorf("Status.ConfigHash = %q did not change with the config", previous)
+			}
+			if ok != tt.wantAnnotation || (ok && hash != instance.Status.ConfigHash) {
+				t.Errorf("%s = %q (set %v) after the config change, want %q (set %v)", configHashAnnotation, hash, ok, instance.Status.ConfigHash, tt.wantAnnotation)
+			}
+		})
+	}
+}
+
+func TestMigrateDeploymentSelector(t *testing.T) {
+	tests := []struct {
+		name        string
+		selector    map[string]string
+		allow       bool
+		wantErr     bool
+		wantDeleted bool
+	}{
+		{name: "selector unchanged", selector: map[string]string{"app": "argo"}},
+		{name: "selector changed", selector: map[string]string{"app": "argo", "legacy": "true"}, wantErr: true},
+		{name: "selector changed with migration allowed", selector: map[string]string{"app": "argo", "legacy": "true"}, allow: true, wantDeleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.AllowSelectorMigration = tt.allow
+			live := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "argo", Namespace: "ns"},
+				Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: tt.selector}},
+			}
+			r := newTestReconciler(t, instance, live)
+			desired := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "argo", Namespace: "ns"},
+				Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "argo"}}},
+			}
+
+			err := r.migrateDeploymentSelector(ctx, instance, desired)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("migrateDeploymentSelector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			getErr := r.Get(ctx, client.ObjectKeyFromObject(live), &appsv1.Deployment{})
+			if tt.wantDeleted && !errors.IsNotFound(getErr) {
+				t.Errorf("Deployment error = %v, want it deleted", getErr)
+			}
+			if !tt.wantDeleted && getErr != nil {
+				t.Errorf("Deployment was deleted: %v", getErr)
+			}
+			if gotEvent := recordedEvent(r, "SelectorMigration"); gotEvent != tt.wantDeleted {
+				t.Errorf("SelectorMigration event = %v, want %v", gotEvent, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestReconcileDeploymentRecreatesForSelector(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	instance.Spec.AllowSelectorMigration = true
+	live := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo", Namespace: "ns", UID: "old"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "argo", "legacy": "true"}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "argo", "legacy": "true"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "controller", Image: "controller"}}},
+			},
+		},
+	}
+	r := newTestReconciler(t, instance, live)
+
+	if err := r.reconcileDeployment(ctx, instance); err != nil {
+		t.Fatalf("reconcileDeployment() error = %v", err)
+	}
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(live), deployment); err != nil {
+		t.Fatalf("Deployment was not created again: %v", err)
+	}
+	want := r.makeDeployment(instance, r.Scheme).Spec.Selector
+	if !apiequality.Semantic.DeepEqual(deployment.Spec.Selector, want) {
+		t.Errorf("selector = %v, want %v", deployment.Spec.Selector, want)
+	}
+}
+
+func TestReconcileConfigMapBaseConfigMap(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{BaseConfigMap: "base", InstanceID: "team-a"}
+	base := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "base", Namespace: "ns"},
+		Data:       map[string]string{"config": "instanceID: base\nnodeEvents: {enabled: false}"},
+	}
+	r := newTestReconciler(t, instance, base)
+
+	render := func() (map[string]interface{}, string) {
+		t.Helper()
+		if err := r.reconcileConfigMap(ctx, instance); err != nil {
+			t.Fatalf("reconcileConfigMap() error = %v", err)
+		}
+		configMap := &corev1.ConfigMap{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: generatedConfigMapName(instance)}, configMap); err != nil {
+			t.Fatal(err)
+		}
+		var config map[string]interface{}
+		if err := yaml.Unmarshal([]byte(configMap.Data["config"]), &config); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := r.controllerConfigHash(ctx, instance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config, hash
+	}
+
+	config, hash := render()
+	if config["instanceID"] != "team-a" {
+		t.Errorf("instanceID = %v, want the generated value to win", config["instanceID"])
+	}
+	if !reflect.DeepEqual(config["nodeEvents"], map[string]interface{}{"enabled": false}) {
+		t.Errorf("nodeEvents = %v, want the base value", config["nodeEvents"])
+	}
+
+	// A change of the base ConfigMap reaches the controller config and rolls
+	// the pods.
+	base.Data["config"] = "nodeEvents: {enabled: true}"
+	if err := r.Update(ctx, base); err != nil {
+		t.Fatal(err)
+	}
+	config, changedHash := render()
+	if !reflect.DeepEqual(config["nodeEvents"], map[string]interface{}{"enabled": true}) {
+		t.Errorf("nodeEvents = %v after the base change, want the new base value", config["nodeEvents"])
+	}
+	if changedHash == hash {
+		t.Errorf("config hash %q did not change with the base ConfigMap", hash)
+	}
+
+	if err := r.Get(ctx, client.ObjectKeyFromObject(base), base); err != nil {
+		t.Fatal(err)
+	}
+	if len(base.OwnerReferences) > 0 || base.Data["config"] != "nodeEvents: {enabled: true}" {
+		t.Errorf("base ConfigMap was modified: %v", base)
+	}
+}
+
+func TestHandleManualScale(t *testing.T) {
+	tests := []struct {
+		name         string
+		ignore       bool
+		specReplicas int32
+		want         int32
+		wantEvent    bool
+	}{
+		{name: "manual scale overridden", specReplicas: 1, want: 1, wantEvent: true},
+		{name: "manual scale kept", ignore: true, specReplicas: 1, want: 5},
+		{name: "changed spec wins over a kept manual scale", ignore: true, specReplicas: 2, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.IgnoreManualScale = tt.ignore
+			r := newTestReconciler(t, instance)
+			if err := r.reconcileDeployment(ctx, instance); err != nil {
+				t.Fatalf("reconcileDeployment() error = %v", err)
+			}
+			// Drop the events of creating the Deployment.
+			recordedEvent(r, "")
+
+			deployment := &appsv1.Deployment{}
+			key := client.ObjectKey{Namespace: "ns", Name: "argo"}
+			if err := r.Get(ctx, key, deployment); err != nil {
+				t.Fatal(err)
+			}
+			deployment.Spec.Replicas = pointer.Int32(5)
+			if err := r.Update(ctx, deployment); err != nil {
+				t.Fatal(err)
+			}
+
+			instance.Spec.Replicas = tt.specReplicas
+			if err := r.reconcileDeployment(ctx, instance); err != nil {
+				t.Fatalf("second reconcileDeployment() error = %v", err)
+			}
+			if err := r.Get(ctx, key, deployment); err != nil {
+				t.Fatal(err)
+			}
+			if *deployment.Spec.Replicas != tt.want {
+				t.Errorf("replicas = %d, want %d", *deployment.Spec.Replicas, tt.want)
+			}
+			if got := deployment.Annotations[appliedReplicasAnnotation]; got != strconv.Itoa(int(tt.specReplicas)) {
+				t.Errorf("%s = %q, want %d", appliedReplicasAnnotation, got, tt.specReplicas)
+			}
+			if gotEvent := recordedEvent(r, "ManualScaleOverridden"); gotEvent != tt.wantEvent {
+				t.Errorf("ManualScaleOverridden event = %v, want %v", gotEvent, tt.wantEvent)
+			}
+			replicas, err := r.controllerReplicas(ctx, instance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replicas != tt.want {
+				t.Errorf("controllerReplicas() = %d, want %d", replicas, tt.want)
+			}
+		})
+	}
+}
+
+func TestPProf(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		extraPorts []corev1.ContainerPort
+		wantErr    bool
+	}{
+		{name: "disabled"},
+		{name: "enabled", enabled: true},
+		{name: "disabled with an extra port on the pprof port", extraPorts: []corev1.ContainerPort{{Name: "debug", ContainerPort: pprofPort}}},
+		{name: "enabled with an extra port on the pprof port", enabled: true, extraPorts: []corev1.ContainerPort{{Name: "debug", ContainerPort: pprofPort}}, wantErr: true},
+		{name: "enabled with an extra port named pprof", enabled: true, extraPorts: []corev1.ContainerPort{{Name: "pprof", ContainerPort: 7070}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{PProf: &stackv1alpha1.PProfSpec{Enabled: tt.enabled}}
+			if tt.extraPorts != nil {
+				instance.Spec.Controller = &stackv1alpha1.ControllerSpec{ExtraPorts: tt.extraPorts}
+			}
+			r := newTestReconciler(t, instance)
+
+			err := r.reconcileDeployment(ctx, instance)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reconcileDeployment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			container := r.makeDeployment(instance, r.Scheme).Spec.Template.Spec.Containers[0]
+			env := ""
+			for _, e := range container.Env {
+				if e.Name == "ARGO_PPROF" {
+					env = e.Value
+				}
+			}
+			containerPort := false
+			for _, port := range container.Ports {
+				if port.Name == "pprof" && port.ContainerPort == pprofPort {
+					containerPort = true
+				}
+			}
+			servicePort := false
+			for _, port := range r.makeService(instance, r.Scheme).Spec.Ports {
+				if port.Name == "pprof" && port.Port == pprofPort {
+					servicePort = true
+				}
+			}
+			wantPort := int32(0)
+			wantEnv := ""
+			if tt.enabled {
+				wantPort, wantEnv = pprofPort, "true"
+			}
+			if env != wantEnv {
+				t.Errorf("ARGO_PPROF = %q, want %q", env, wantEnv)
+			}
+			if containerPort != tt.enabled || servicePort != tt.enabled {
+				t.Errorf("pprof container port = %v, service port = %v, want %v", containerPort, servicePort, tt.enabled)
+			}
+			if instance.Status.PProfPort != wantPort {
+				t.Errorf("Status.PProfPort = %d, want %d", instance.Status.PProfPort, wantPort)
+			}
+		})
+	}
+}
+
+func TestMakeControllerArgsWorkers(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *stackv1alpha1.ControllerConfigSpec
+		want   map[string]string
+		absent []string
+	}{
+		{
+			name:   "defaults",
+			want:   map[string]string{"--workflow-workers": "32"},
+			absent: []string{"--pod-workers", "--pod-cleanup-workers", "--workflow-ttl-workers"},
+		},
+		{
+			name:   "unset workflow workers keep the default",
+			config: &stackv1alpha1.ControllerConfigSpec{PodWorkers: 8},
+			want:   map[string]string{"--workflow-workers": "32", "--pod-workers": "8"},
+			absent: []string{"--pod-cleanup-workers", "--workflow-ttl-workers"},
+		},
+		{
+			name:   "all workers",
+			config: &stackv1alpha1.ControllerConfigSpec{WorkflowWorkers: 64, PodWorkers: 16, PodCleanupWorkers: 4, WorkflowTTLWorkers: 2},
+			want:   map[string]string{"--workflow-workers": "64", "--pod-workers": "16", "--pod-cleanup-workers": "4", "--workflow-ttl-workers": "2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.ControllerConfig = tt.config
+			args := makeControllerArgs(instance)
+			for flag, want := range tt.want {
+				if got, ok := argValue(args, flag); !ok || got != want {
+					t.Errorf("%s = %q (set %v), want %q", flag, got, ok, want)
+				}
+			}
+			for _, flag := range tt.absent {
+				if got, ok := argValue(args, flag); ok {
+					t.Errorf("%s = %q, want it unset", flag, got)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateZoneReadiness(t *testing.T) {
+	node := func(name, zone string) *corev1.Node {
+		n := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if zone != "" {
+			n.Labels = map[string]string{corev1.LabelTopologyZone: zone}
+		}
+		return n
+	}
+	pod := func(name, nodeName string, ready bool) *corev1.Pod {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: map[string]string{"app": "argo"}},
+			Spec:       corev1.PodSpec{NodeName: nodeName},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}},
+		}
+	}
+	nodes := []client.Object{node("node-a1", "zone-a"), node("node-a2", "zone-a"), node("node-b", "zone-b"), node("node-unlabeled", "")}
+	tests := []struct {
+		name string
+		pods []*corev1.Pod
+		want map[string]int32
+	}{
+		{name: "no pods", want: map[string]int32{}},
+		{
+			name: "pods across zones",
+			pods: []*corev1.Pod{pod("a", "node-a1", true), pod("b", "node-a2", true), pod("c", "node-b", true)},
+			want: map[string]int32{"zone-a": 2, "zone-b": 1},
+		},
+		{
+			name: "pods not ready or not scheduled",
+			pods: []*corev1.Pod{pod("a", "node-a1", false), pod("b", "", true), pod("c", "node-b", true)},
+			want: map[string]int32{"zone-b": 1},
+		},
+		{
+			name: "node without zone label",
+			pods: []*corev1.Pod{pod("a", "node-unlabeled", true)},
+			want: map[string]int32{unknownZone: 1},
+		},
+		{
+			name: "node gone",
+			pods: []*corev1.Pod{pod("a", "node-deleted", true), pod("b", "node-b", true)},
+			want: map[string]int32{"zone-b": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			objs := append([]client.Object{instance}, nodes...)
+			for _, p := range tt.pods {
+				objs = append(objs, p)
+			}
+			r := newTestReconciler(t, objs...)
+
+			if err := r.updateZoneReadiness(ctx, instance); err != nil {
+				t.Fatalf("updateZoneReadiness() error = %v", err)
+			}
+			if !reflect.DeepEqual(instance.Status.ZoneReadiness, tt.want) {
+				t.Errorf("ZoneReadiness = %v, want %v", instance.Status.ZoneReadiness, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckInstanceID(t *testing.T) {
+	withID := func(instance *stackv1alpha1.ArgoWorkFlow, id string) *stackv1alpha1.ArgoWorkFlow {
+		instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{InstanceID: id}
+		return instance
+	}
+	longName := strings.Repeat("a", 60)
+	tests := []struct {
+		name         string
+		instance     *stackv1alpha1.ArgoWorkFlow
+		other        *stackv1alpha1.ArgoWorkFlow
+		wantID       string
+		wantConflict bool
+	}{
+		{
+			name:     "both default",
+			instance: newTestArgoWorkFlow("ns", "argo"),
+			other:    newTestArgoWorkFlow("ns", "other"),
+			wantID:   "ns-argo",
+		},
+		{
+			name:         "same explicit id",
+			instance:     withID(newTestArgoWorkFlow("ns", "argo"), "team-a"),
+			other:        withID(newTestArgoWorkFlow("ns", "other"), "team-a"),
+			wantID:       "team-a",
+			wantConflict: true,
+		},
+		{
+			name:         "explicit id matches other default",
+			instance:     withID(newTestArgoWorkFlow("ns", "argo"), "ns-other"),
+			other:        newTestArgoWorkFlow("ns", "other"),
+			wantID:       "ns-other",
+			wantConflict: true,
+		},
+		{
+			name:     "long name",
+			instance: newTestArgoWorkFlow("ns", longName),
+			other:    newTestArgoWorkFlow("ns", "other"),
+			wantID:   defaultInstanceID(newTestArgoWorkFlow("ns", longName)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(t, tt.instance.DeepCopy(), tt.other)
+			ctx := context.Background()
+
+			if err := r.checkInstanceID(ctx, tt.instance); err != nil {
+				t.Fatalf("checkInstanceID() error = %v", err)
+			}
+			condition := apimeta.FindStatusCondition(tt.instance.Status.Conditions, stackv1alpha1.ConditionTypeInstanceID)
+			if conflict := condition != nil && condition.Status == metav1.ConditionTrue; conflict != tt.wantConflict {
+				t.Errorf("conflict = %v, want %v (condition %+v)", conflict, tt.wantConflict, condition)
+			}
+
+			if errs := validation.IsValidLabelValue(tt.wantID); len(errs) > 0 {
+				t.Fatalf("instance ID %q is not a valid label value: %v", tt.wantID, errs)
+			}
+			data, err := r.renderControllerConfig(ctx, tt.instance)
+			if err != nil {
+				t.Fatalf("renderControllerConfig() error = %v", err)
+			}
+			config := controllerConfig{}
+			if err := yaml.Unmarshal(data, &config); err != nil {
+				t.Fatal(err)
+			}
+			if config.InstanceID != tt.wantID {
+				t.Errorf("rendered instanceID = %q, want %q", config.InstanceID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestMakeControllerArgsConfigMap(t *testing.T) {
+	tests := []struct {
+		name      string
+		configMap *stackv1alpha1.ConfigMapSpec
+		existing  string
+		want      string
+	}{
+		{name: "default", want: "argo-controller"},
+		{name: "custom name", configMap: &stackv1alpha1.ConfigMapSpec{Name: "custom"}, want: "custom"},
+		{name: "existing configmap", configMap: &stackv1alpha1.ConfigMapSpec{Name: "custom"}, existing: "mine", want: "mine"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.ConfigMap = tt.configMap
+			if tt.existing != "" {
+				instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{ExistingConfigMap: tt.existing}
+			}
+			got, ok := argValue(makeControllerArgs(instance), "--configmap")
+			if !ok || got != tt.want {
+				t.Errorf("--configmap = %q (set: %v), want %q", got, ok, tt.want)
+			}
+			if tt.existing == "" && got != generatedConfigMapName(instance) {
+				t.Errorf("--configmap = %q, want the generated ConfigMap %q", got, generatedConfigMapName(instance))
+			}
+		})
+	}
+}
+
+func TestReconcileConfigMapMirror(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	instance.Spec.ConfigMap = &stackv1alpha1.ConfigMapSpec{MirrorName: "argo-backup"}
+	deletes := 0
+	c := newTestClientBuilder(t, instance).WithInterceptorFuncs(interceptor.Funcs{
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if obj.GetName() == "argo-backup" {
+				deletes++
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+	}).Build()
+	r := newTestReconcilerFor(c)
+
+	reconcileMirror := func() *corev1.ConfigMap {
+		t.Helper()
+		if err := r.reconcileConfigMap(ctx, instance); err != nil {
+			t.Fatalf("reconcileConfigMap() error = %v", err)
+		}
+		configMap, mirror := &corev1.ConfigMap{}, &corev1.ConfigMap{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo-controller"}, configMap); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo-backup"}, mirror); err != nil {
+			t.Fatalf("mirror not created: %v", err)
+		}
+		if mirror.Immutable == nil || !*mirror.Immutable {
+			t.Errorf("mirror Immutable = %v, want true", mirror.Immutable)
+		}
+		if !reflect.DeepEqual(mirror.Data, configMap.Data) {
+			t.Errorf("mirror data = %v, want %v", mirror.Data, configMap.Data)
+		}
+		return mirror
+	}
+
+	reconcileMirror()
+	reconcileMirror()
+	if deletes != 0 {
+		t.Errorf("unchanged mirror deleted %d times, want 0", deletes)
+	}
+
+	instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{InstanceID: "team-a"}
+	mirror := reconcileMirror()
+	if deletes != 1 {
+		t.Errorf("changed mirror deleted %d times, want 1", deletes)
+	}
+	if !strings.Contains(mirror.Data["config"], "team-a") {
+		t.Errorf("mirror config = %q, want the updated instance ID", mirror.Data["config"])
+	}
+}
+
+func TestReconcileConfigMapMirrorName(t *testing.T) {
+	tests := []struct {
+		name      string
+		configMap *stackv1alpha1.ConfigMapSpec
+		wantErr   bool
+	}{
+		{name: "default name", configMap: &stackv1alpha1.ConfigMapSpec{MirrorName: "argo-controller"}, wantErr: true},
+		{name: "custom name", configMap: &stackv1alpha1.ConfigMapSpec{Name: "config", MirrorName: "config"}, wantErr: true},
+		{name: "previous default name", configMap: &stackv1alpha1.ConfigMapSpec{Name: "config", MirrorName: "argo-controller"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.ConfigMap = tt.configMap
+			r := newTestReconciler(t, instance)
+
+			err := r.reconcileConfigMap(context.Background(), instance)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reconcileConfigMap() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				return
+			}
+			configMap := &corev1.ConfigMap{}
+			if err := r.Get(context.Background(), client.ObjectKey{Namespace: "ns", Name: tt.configMap.MirrorName}, configMap); !errors.IsNotFound(err) {
+				t.Errorf("Get(%s) error = %v, want NotFound", tt.configMap.MirrorName, err)
+			}
+		})
+	}
+}
+
+func TestValidateMetricsTLSSecret(t *testing.T) {
+	tlsSecret := func(data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "metrics-tls", Namespace: "ns"}, Data: data}
+	}
+	tests := []struct {
+		name          string
+		metrics       *stackv1alpha1.MetricsSpec
+		secret        *corev1.Secret
+		wantErr       string
+		wantCondition metav1.ConditionStatus
+		wantReason    string
+	}{
+		{
+			name:    "self-signed",
+			metrics: &stackv1alpha1.MetricsSpec{Secure: pointer.Bool(true)},
+		},
+		{
+			name:    "not secure",
+			metrics: &stackv1alpha1.MetricsSpec{TLSSecret: "metrics-tls"},
+		},
+		{
+			name:          "secret found",
+			metrics:       &stackv1alpha1.MetricsSpec{Secure: pointer.Bool(true), TLSSecret: "metrics-tls"},
+			secret:        tlsSecret(map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("key")}),
+			wantCondition: metav1.ConditionTrue,
+			wantReason:    stackv1alpha1.ConditionReasonTLSSecretFound,
+		},
+		{
+			name:          "missing secret",
+			metrics:       &stackv1alpha1.MetricsSpec{Secure: pointer.Bool(true), TLSSecret: "metrics-tls"},
+			wantErr:       `metrics tls secret "metrics-tls" not found`,
+			wantCondition: metav1.ConditionFalse,
+			wantReason:    stackv1alpha1.ConditionReasonTLSSecretMissing,
+		},
+		{
+			name:          "missing key",
+			metrics:       &stackv1alpha1.MetricsSpec{Secure: pointer.Bool(true), TLSSecret: "metrics-tls"},
+			secret:        tlsSecret(map[string][]byte{corev1.TLSCertKey: []byte("cert")}),
+			wantErr:       `metrics tls secret "metrics-tls" has no "tls.key" key`,
+			wantCondition: metav1.ConditionFalse,
+			wantReason:    stackv1alpha1.ConditionReasonTLSSecretMissing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.Metrics = tt.metrics
+			var objs []client.Object
+			if tt.secret != nil {
+				objs = append(objs, tt.secret)
+			}
+			r := newTestReconciler(t, objs...)
+
+			err := r.validateMetricsTLSSecret(context.Background(), instance)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("validateMetricsTLSSecret() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("validateMetricsTLSSecret() error = %v", err)
+			}
+
+			condition := apimeta.FindStatusCondition(instance.Status.Conditions, stackv1alpha1.ConditionTypeMetricsTLS)
+			if tt.wantCondition == "" {
+				if condition != nil {
+					t.Errorf("unexpected condition %+v", condition)
+				}
+				return
+			}
+			if condition == nil || condition.Status != tt.wantCondition || condition.Reason != tt.wantReason {
+				t.Errorf("condition = %+v, want %s/%s", condition, tt.wantCondition, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestMakeDeploymentMetricsTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics *stackv1alpha1.MetricsSpec
+		want    bool
+	}{
+		{name: "self-signed", metrics: &stackv1alpha1.MetricsSpec{Secure: pointer.Bool(true)}},
+		{name: "not secure", metrics: &stackv1alpha1.MetricsSpec{TLSSecret: "metrics-tls"}},
+		{name: "tls secret", metrics: &stackv1alpha1.MetricsSpec{Secure: pointer.Bool(true), TLSSecret: "metrics-tls"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.Metrics = tt.metrics
+			r := newTestReconciler(t)
+
+			podSpec := r.makeDeployment(instance, r.Scheme).Spec.Template.Spec
+			var volume *corev1.Volume
+			for i := range podSpec.Volumes {
+				if podSpec.Volumes[i].Name == metricsTLSVolumeName {
+					volume = &podSpec.Volumes[i]
+				}
+			}
+			if (volume != nil) != tt.want {
+				t.Fatalf("metrics tls volume = %+v, want present %v", volume, tt.want)
+			}
+			mounted := false
+			for _, mount := range podSpec.Containers[0].VolumeMounts {
+				mounted = mounted || (mount.Name == metricsTLSVolumeName && mount.MountPath == metricsTLSMountPath && mount.ReadOnly)
+			}
+			if mounted != tt.want {
+				t.Errorf("metrics tls mounted = %v, want %v", mounted, tt.want)
+			}
+			env := map[string]string{}
+			for _, v := range podSpec.Containers[0].Env {
+				env[v.Name] = v.Value
+			}
+			if !tt.want {
+				if _, ok := env["METRICS_TLS_CERT_FILE"]; ok {
+					t.Errorf("METRICS_TLS_CERT_FILE set without a tls secret")
+				}
+				return
+			}
+			if volume.Secret == nil || volume.Secret.SecretName != "metrics-tls" {
+				t.Errorf("metrics tls volume source = %+v, want secret metrics-tls", volume.VolumeSource)
+			}
+			if got := env["METRICS_TLS_CERT_FILE"]; got != "/etc/argo/metrics-tls/tls.crt" {
+				t.Errorf("METRICS_TLS_CERT_FILE = %q", got)
+			}
+			if got := env["METRICS_TLS_KEY_FILE"]; got != "/etc/argo/metrics-tls/tls.key" {
+				t.Errorf("METRICS_TLS_KEY_FILE = %q", got)
+			}
+		})
+	}
+}
+
+func TestReconcileLease(t *testing.T) {
+	holder := "argo-7d9f-x2k4"
+	existing := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "workflow-controller-ns-argo", Namespace: "ns"},
+		Spec:       coordinationv1.LeaseSpec{HolderIdentity: &holder},
+	}
+	tests := []struct {
+		name           string
+		leaderElection *stackv1alpha1.LeaderElectionSpec
+		existing       *coordinationv1.Lease
+		wantLease      bool
+		wantHolder     string
+	}{
+		{name: "pre-create disabled"},
+		{name: "leader election disabled", leaderElection: &stackv1alpha1.LeaderElectionSpec{Enabled: pointer.Bool(false), PreCreateLease: true}},
+		{name: "created", leaderElection: &stackv1alpha1.LeaderElectionSpec{PreCreateLease: true}, wantLease: true},
+		{name: "existing lease left alone", leaderElection: &stackv1alpha1.LeaderElectionSpec{PreCreateLease: true}, existing: existing, wantLease: true, wantHolder: holder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.LeaderElection = tt.leaderElection
+			var objs []client.Object
+			if tt.existing != nil {
+				objs = append(objs, tt.existing.DeepCopy())
+			}
+			r := newTestReconciler(t, objs...)
+
+			if err := r.reconcileLease(ctx, instance); err != nil {
+				t.Fatalf("reconcileLease() error = %v", err)
+			}
+			lease := &coordinationv1.Lease{}
+			err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: leaseName(instance)}, lease)
+			if !tt.wantLease {
+				if !errors.IsNotFound(err) {
+					t.Errorf("get Lease error = %v, want NotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("get Lease: %v", err)
+			}
+			if got := pointer.StringDeref(lease.Spec.HolderIdentity, ""); got != tt.wantHolder {
+				t.Errorf("HolderIdentity = %q, want %q", got, tt.wantHolder)
+			}
+			if tt.existing == nil && !metav1.IsControlledBy(lease, instance) {
+				t.Errorf("created Lease is not controlled by the instance: %+v", lease.OwnerReferences)
+			}
+			if tt.existing != nil && len(lease.OwnerReferences) != 0 {
+				t.Errorf("existing Lease was updated: %+v", lease.OwnerReferences)
+			}
+		})
+	}
+}
+
+func TestReconcileMetricsService(t *testing.T) {
+	ctx := context.Background()
+	instance := newTestArgoWorkFlow("ns", "argo")
+	r := newTestReconciler(t, instance)
+	key := client.ObjectKey{Namespace: "ns", Name: "argo-metrics"}
+
+	for _, separate := range []bool{true, false, true, false} {
+		instance.Spec.Metrics = &stackv1alpha1.MetricsSpec{SeparateService: separate}
+		if err := r.reconcileMetricsService(ctx, instance); err != nil {
+			t.Fatalf("reconcileMetricsService(separate=%v) error = %v", separate, err)
+		}
+		svc := &corev1.Service{}
+		err := r.Get(ctx, key, svc)
+		if !separate {
+			if !errors.IsNotFound(err) {
+				t.Errorf("separate=false: get metrics Service error = %v, want NotFound", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("separate=true: get metrics Service: %v", err)
+		}
+		if got := svc.Labels["app.kubernetes.io/component"]; got != "metrics" {
+			t.Errorf("component label = %q, want metrics", got)
+		}
+		if len(svc.Spec.Ports) != 1 || svc.Spec.Ports[0].Name != "metrics" || svc.Spec.Ports[0].Port != 9090 {
+			t.Errorf("ports = %+v, want a single port named metrics on 9090", svc.Spec.Ports)
+		}
+	}
+
+	controllerService := r.makeService(instance, r.Scheme)
+	if controllerService.Labels["app.kubernetes.io/component"] == "metrics" {
+		t.Error("controller Service carries the metrics component label")
+	}
+}
+
+func TestReconcileConfigMapDisablesOptional(t *testing.T) {
+	tests := []struct {
+		name    string
+		enable  func(instance *stackv1alpha1.ArgoWorkFlow)
+		disable func(instance *stackv1alpha1.ArgoWorkFlow)
+		object  string
+	}{
+		{
+			name: "mirror",
+			enable: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.ConfigMap = &stackv1alpha1.ConfigMapSpec{MirrorName: "argo-backup"}
+			},
+			disable: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.ConfigMap = nil
+			},
+			object: "argo-backup",
+		},
+		{
+			name: "renamed mirror",
+			enable: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.ConfigMap = &stackv1alpha1.ConfigMapSpec{MirrorName: "argo-backup"}
+			},
+			disable: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.ConfigMap = &stackv1alpha1.ConfigMapSpec{MirrorName: "argo-backup-2"}
+			},
+			object: "argo-backup",
+		},
+		{
+			name:   "generated configmap",
+			enable: func(instance *stackv1alpha1.ArgoWorkFlow) {},
+			disable: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{ExistingConfigMap: "shared"}
+			},
+			object: "argo-controller",
+		},
+		{
+			name: "mirror of a generated configmap",
+			enable: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.ConfigMap = &stackv1alpha1.ConfigMapSpec{MirrorName: "argo-backup"}
+			},
+			disable: func(instance *stackv1alpha1.ArgoWorkFlow) {
+				instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{ExistingConfigMap: "shared"}
+			},
+			object: "argo-backup",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			shared := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "ns"}}
+			r := newTestReconciler(t, instance, shared)
+			key := client.ObjectKey{Namespace: "ns", Name: tt.object}
+
+			tt.enable(instance)
+			if err := r.reconcileConfigMap(ctx, instance); err != nil {
+				t.Fatalf("enabled: reconcileConfigMap() error = %v", err)
+			}
+			if err := r.Get(ctx, key, &corev1.ConfigMap{}); err != nil {
+				t.Fatalf("enabled: get %s: %v", tt.object, err)
+			}
+
+			tt.disable(instance)
+			for i := 0; i < 2; i++ {
+				if err := r.reconcileConfigMap(ctx, instance); err != nil {
+					t.Fatalf("disabled, pass %d: reconcileConfigMap() error = %v", i+1, err)
+				}
+			}
+			if err := r.Get(ctx, key, &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+				t.Errorf("disabled: get %s error = %v, want NotFound", tt.object, err)
+			}
+			if err := r.Get(ctx, client.ObjectKeyFromObject(shared), &corev1.ConfigMap{}); err != nil {
+				t.Errorf("shared configmap removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestArgoVersionBefore(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{tag: "3.5.0", want: true},
+		{tag: "v3.5.12", want: true},
+		{tag: "3.5.0-debian-11-r0", want: true},
+		{tag: "v3.6.0"},
+		{tag: "3.10.1"},
+		{tag: "v4.0.0"},
+		{tag: "latest"},
+		{tag: "sha256:0123abcd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			if got := argoVersionBefore(tt.tag, minOTLPArgoVersion); got != tt.want {
+				t.Errorf("argoVersionBefore(%q, %q) = %v, want %v", tt.tag, minOTLPArgoVersion, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUnsupportedOTLPEndpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		tag        string
+		endpoint   string
+		overrides  bool
+		wantReason string
+	}{
+		{name: "unset", tag: "v3.5.2"},
+		{name: "old image", tag: "v3.5.2", endpoint: "http://otel:4318", wantReason: stackv1alpha1.ConditionReasonOTLPUnsupported},
+		{name: "supported image", tag: "v3.6.2", endpoint: "http://otel:4318"},
+		{name: "unknown version", tag: "latest", endpoint: "http://otel:4318"},
+		{name: "with namespace overrides", tag: "v3.5.2", endpoint: "http://otel:4318", overrides: true, wantReason: stackv1alpha1.ConditionReasonNamespaceOverrides},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.Image.Tag = tt.tag
+			if tt.endpoint != "" {
+				instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{Telemetry: &stackv1alpha1.TelemetrySpec{OTLPEndpoint: tt.endpoint}}
+			}
+			if tt.overrides {
+				instance.Spec.NamespaceParallelismOverrides = map[string]int32{"team-a": 5}
+			}
+			r := newTestReconciler(t)
+
+			r.checkUnsupportedConfig(instance)
+			condition := apimeta.FindStatusCondition(instance.Status.Conditions, stackv1alpha1.ConditionTypeUnsupported)
+			if tt.wantReason == "" {
+				if condition != nil {
+					t.Errorf("unexpected condition %+v", condition)
+				}
+				return
+			}
+			if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != tt.wantReason {
+				t.Fatalf("condition = %+v, want True/%s", condition, tt.wantReason)
+			}
+			if !strings.Contains(condition.Message, "otlpEndpoint") || !strings.Contains(condition.Message, minOTLPArgoVersion) {
+				t.Errorf("message = %q, want the otlpEndpoint minimum version", condition.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	preDeleteHookFinalizer = "stack.zncdata.net/pre-delete-hook"
+	// instanceIDLabel selects the workflow-controller a Workflow is run by
+	// when the controllers are configured with an instance ID.
+	instanceIDLabel = "workflows.argoproj.io/controller-instanceid"
+	// preDeleteHookInterval is how often a running pre-delete Workflow is
+	// re-checked.
+	preDeleteHookInterval = 10 * time.Second
+)
+
+var workflowGVK = schema.GroupVersionKind{Group: "argoproj.io", Version: argoAPIVersion, Kind: "Workflow"}
+
+// reconcilePreDeleteFinalizer adds the pre-delete hook finalizer while a hook
+// is configured and removes it once the hook is dropped from the spec.
+func (r *ArgoWorkFlowReconciler) reconcilePreDeleteFinalizer(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) error {
+	changed := false
+	if instance.Spec.PreDeleteHook != nil {
+		changed = controllerutil.AddFinalizer(instance, preDeleteHookFinalizer)
+	} else {
+		changed = controllerutil.RemoveFinalizer(instance, preDeleteHookFinalizer)
+	}
+	if !changed {
+		return nil
+	}
+	return r.updateFinalizers(ctx, instance)
+}
+
+func (r *ArgoWorkFlowReconciler) makePreDeleteWorkflow(instance *stackv1alpha1.ArgoWorkFlow) (*unstructured.Unstructured, error) {
+	workflow := &unstructured.Unstructured{}
+	workflow.SetGroupVersionKind(workflowGVK)
+	workflow.SetName(instance.GetNameWithSuffix("-pre-delete"))
+	workflow.SetNamespace(instance.Namespace)
+	labels := makeLabels(instance, "pre-delete-hook")
+	if id := instanceID(instance); id != "" {
+		labels[instanceIDLabel] = id
+	}
+	workflow.SetLabels(labels)
+	if err := unstructured.SetNestedField(workflow.Object, instance.Spec.PreDeleteHook.WorkflowTemplateRef, "spec", "workflowTemplateRef", "name"); err != nil {
+		return nil, err
+	}
+	if err := ctrl.SetControllerReference(instance, workflow, r.Scheme); err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+// runPreDeleteHook launches the pre-delete Workflow and reports whether the
+// deletion may proceed: once the Workflow finished or the timeout, counted
+// from the first attempt, expired. Once the timeout expired, errors getting or
+// creating the Workflow skip the hook instead of blocking the deletion.
+// Progress is kept in the PreDeleteHook condition.
+func (r *ArgoWorkFlowReconciler) runPreDeleteHook(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) (bool, error) {
+	hook := instance.Spec.PreDeleteHook
+	if hook == nil || !controllerutil.ContainsFinalizer(instance, preDeleteHookFinalizer) {
+		return true, nil
+	}
+
+	if instance.Status.PreDeleteHookStartTime == nil {
+		now := metav1.Now()
+		instance.Status.PreDeleteHookStartTime = &now
+	}
+	timeout := time.Duration(hook.TimeoutSeconds) * time.Second
+	expired := time.Since(instance.Status.PreDeleteHookStartTime.Time) > timeout
+
+	condition := metav1.Condition{
+		Type:               stackv1alpha1.ConditionTypePreDelete,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: instance.GetGeneration(),
+	}
+	workflow, err := r.getOrCreatePreDeleteWorkflow(ctx, instance)
+	if err != nil {
+		if !expired {
+			return false, err
+		}
+		condition.Reason = stackv1alpha1.ConditionReasonHookSkipped
+		condition.Message = fmt.Sprintf("Pre-delete hook skipped, it could not be run within %s: %s", timeout, err)
+		r.Recorder.Event(instance, corev1.EventTypeWarning, "PreDeleteHookSkipped", condition.Message)
+		instance.SetStatusCondition(condition)
+		controllerutil.RemoveFinalizer(instance, preDeleteHookFinalizer)
+		return true, r.updateFinalizers(ctx, instance)
+	}
+
+	phase, _, _ := unstructured.NestedString(workflow.Object, "status", "phase")
+	switch phase {
+	case "Succeeded":
+		condition.Reason = stackv1alpha1.ConditionReasonHookSucceeded
+		condition.Message = fmt.Sprintf("Pre-delete Workflow %s succeeded", workflow.GetName())
+	case "Failed", "Error":
+		condition.Reason = stackv1alpha1.ConditionReasonHookFailed
+		condition.Message = fmt.Sprintf("Pre-delete Workflow %s finished with phase %s", workflow.GetName(), phase)
+	default:
+		if !expired {
+			instance.SetStatusCondition(metav1.Condition{
+				Type:               stackv1alpha1.ConditionTypePreDelete,
+				Status:             metav1.ConditionTrue,
+				Reason:             stackv1alpha1.ConditionReasonHookRunning,
+				Message:            fmt.Sprintf("Waiting for pre-delete Workflow %s", workflow.GetName()),
+				ObservedGeneration: instance.GetGeneration(),
+			})
+			return false, nil
+		}
+		condition.Reason = stackv1alpha1.ConditionReasonHookTimedOut
+		condition.Message = fmt.Sprintf("Pre-delete Workflow %s did not finish within %s", workflow.GetName(), timeout)
+	}
+	instance.SetStatusCondition(condition)
+
+	controllerutil.RemoveFinalizer(instance, preDeleteHookFinalizer)
+	return true, r.updateFinalizers(ctx, instance)
+}
+
+// getOrCreatePreDeleteWorkflow returns the pre-delete Workflow of the
+// instance, creating it on the first call.
+func (r *ArgoWorkFlowReconciler) getOrCreatePreDeleteWorkflow(ctx context.Context, instance *stackv1alpha1.ArgoWorkFlow) (*unstructured.Unstructured, error) {
+	desired, err := r.makePreDeleteWorkflow(instance)
+	if err != nil {
+		return nil, err
+	}
+	workflow := &unstructured.Unstructured{}
+	workflow.SetGroupVersionKind(workflowGVK)
+	err = r.Get(ctx, client.ObjectKeyFromObject(desired), workflow)
+	if errors.IsNotFound(err) {
+		r.Log.Info("Starting pre-delete hook", "WorkflowTemplate", instance.Spec.PreDeleteHook.WorkflowTemplateRef)
+		if err := r.Create(ctx, desired); err != nil {
+			return nil, err
+		}
+		return desired, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
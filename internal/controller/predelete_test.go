@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	stackv1alpha1 "github.com/zncdata-labs/argo-workflow-operator/api/v1alpha1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestMakePreDeleteWorkflowInstanceID(t *testing.T) {
+	tests := []struct {
+		name       string
+		instanceID string
+		want       string
+	}{
+		{name: "default instance id", want: "ns-argo"},
+		{name: "instance id", instanceID: "team-a", want: "team-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.PreDeleteHook = &stackv1alpha1.PreDeleteHookSpec{WorkflowTemplateRef: "cleanup", TimeoutSeconds: 300}
+			if tt.instanceID != "" {
+				instance.Spec.ControllerConfig = &stackv1alpha1.ControllerConfigSpec{InstanceID: tt.instanceID}
+			}
+			r := newTestReconciler(t)
+
+			workflow, err := r.makePreDeleteWorkflow(instance)
+			if err != nil {
+				t.Fatalf("makePreDeleteWorkflow() error = %v", err)
+			}
+			got, ok := workflow.GetLabels()[instanceIDLabel]
+			if !ok || got != tt.want {
+				t.Errorf("label %s = %q (set: %v), want %q", instanceIDLabel, got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunPreDeleteHook(t *testing.T) {
+	workflowWithPhase := func(phase string) *unstructured.Unstructured {
+		workflow := &unstructured.Unstructured{}
+		workflow.SetGroupVersionKind(workflowGVK)
+		workflow.SetNamespace("ns")
+		workflow.SetName("argo-pre-delete")
+		if err := unstructured.SetNestedField(workflow.Object, phase, "status", "phase"); err != nil {
+			t.Fatal(err)
+		}
+		return workflow
+	}
+	createFails := interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			return fmt.Errorf("no matches for kind Workflow")
+		},
+	}
+	tests := []struct {
+		name         string
+		workflow     *unstructured.Unstructured
+		startedAgo   time.Duration
+		interceptor  *interceptor.Funcs
+		wantProceed  bool
+		wantErr      bool
+		wantReason   string
+		wantFinalize bool
+	}{
+		{name: "first attempt creates the workflow", wantReason: stackv1alpha1.ConditionReasonHookRunning},
+		{name: "running", workflow: workflowWithPhase("Running"), startedAgo: time.Minute, wantReason: stackv1alpha1.ConditionReasonHookRunning},
+		{name: "succeeded", workflow: workflowWithPhase("Succeeded"), wantProceed: true, wantReason: stackv1alpha1.ConditionReasonHookSucceeded, wantFinalize: true},
+		{name: "failed", workflow: workflowWithPhase("Failed"), wantProceed: true, wantReason: stackv1alpha1.ConditionReasonHookFailed, wantFinalize: true},
+		{name: "timed out", workflow: workflowWithPhase("Running"), startedAgo: 10 * time.Minute, wantProceed: true, wantReason: stackv1alpha1.ConditionReasonHookTimedOut, wantFinalize: true},
+		{name: "create error before the timeout", interceptor: &createFails, wantErr: true},
+		{name: "create error after the timeout", interceptor: &createFails, startedAgo: 10 * time.Minute, wantProceed: true, wantReason: stackv1alpha1.ConditionReasonHookSkipped, wantFinalize: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.PreDeleteHook = &stackv1alpha1.PreDeleteHookSpec{WorkflowTemplateRef: "cleanup", TimeoutSeconds: 300}
+			instance.Finalizers = []string{preDeleteHookFinalizer}
+			if tt.startedAgo > 0 {
+				started := metav1.NewTime(time.Now().Add(-tt.startedAgo))
+				instance.Status.PreDeleteHookStartTime = &started
+			}
+			objs := []client.Object{instance.DeepCopy()}
+			if tt.workflow != nil {
+				objs = append(objs, tt.workflow)
+			}
+			builder := newTestClientBuilder(t, objs...)
+			if tt.interceptor != nil {
+				builder = builder.WithInterceptorFuncs(*tt.interceptor)
+			}
+			r := newTestReconcilerFor(builder.Build())
+			if err := r.Get(ctx, client.ObjectKeyFromObject(instance), instance); err != nil {
+				t.Fatal(err)
+			}
+
+			proceed, err := r.runPreDeleteHook(ctx, instance)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runPreDeleteHook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if proceed != tt.wantProceed {
+				t.Errorf("runPreDeleteHook() = %v, want %v", proceed, tt.wantProceed)
+			}
+			if instance.Status.PreDeleteHookStartTime == nil {
+				t.Errorf("start time of the hook is not recorded")
+			}
+			if tt.wantReason != "" {
+				condition := apimeta.FindStatusCondition(instance.Status.Conditions, stackv1alpha1.ConditionTypePreDelete)
+				if condition == nil || condition.Reason != tt.wantReason {
+					t.Errorf("PreDeleteHook condition = %v, want reason %s", condition, tt.wantReason)
+				}
+			}
+			if tt.wantReason == stackv1alpha1.ConditionReasonHookSkipped {
+				select {
+				case event := <-r.Recorder.(*record.FakeRecorder).Events:
+					if !strings.Contains(event, "PreDeleteHookSkipped") {
+						t.Errorf("event = %q, want PreDeleteHookSkipped", event)
+					}
+				default:
+					t.Errorf("no event recorded for the skipped hook")
+				}
+			}
+			if finalized := !controllerutil.ContainsFinalizer(instance, preDeleteHookFinalizer); finalized != tt.wantFinalize {
+				t.Errorf("finalizer removed = %v, want %v", finalized, tt.wantFinalize)
+			}
+			if tt.workflow == nil && tt.interceptor == nil {
+				workflow := &unstructured.Unstructured{}
+				workflow.SetGroupVersionKind(workflowGVK)
+				if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo-pre-delete"}, workflow); err != nil {
+					t.Errorf("pre-delete Workflow not created: %v", err)
+				}
+			}
+		})
+	}
+}
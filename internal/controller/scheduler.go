@@ -192,5 +192,30 @@ func CreateScheduler(instance *stackv1alpha1.ArgoWorkFlow, dep *appsv1.Deploymen
 				dep.Spec.Template.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = preferredTerms
 			}
 		}
+	} else if instance.Spec.Replicas > 1 {
+		dep.Spec.Template.Spec.Affinity = defaultControllerAffinity(instance)
+	}
+}
+
+// defaultControllerAffinity prefers spreading the controller replicas across
+// nodes. It is only used when no affinity is set.
+func defaultControllerAffinity(instance *stackv1alpha1.ArgoWorkFlow) *corev1.Affinity {
+	return &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: corev1.PodAffinityTerm{
+						TopologyKey: corev1.LabelHostname,
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{
+								"app.kubernetes.io/instance":  instance.Name,
+								"app.kubernetes.io/component": componentController,
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 }
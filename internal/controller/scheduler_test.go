@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestCreateSchedulerDefaultAffinity(t *testing.T) {
+	nodeAffinity := &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"argo"}}},
+				}},
+			},
+		},
+	}
+	tests := []struct {
+		name             string
+		replicas         int32
+		affinity         *corev1.Affinity
+		wantSpread       bool
+		wantNodeAffinity bool
+	}{
+		{name: "single replica", replicas: 1},
+		{name: "several replicas", replicas: 3, wantSpread: true},
+		{name: "several replicas with an affinity", replicas: 3, affinity: nodeAffinity, wantNodeAffinity: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := newTestArgoWorkFlow("ns", "argo")
+			instance.Spec.Replicas = tt.replicas
+			instance.Spec.Affinity = tt.affinity
+			r := newTestReconciler(t, instance)
+
+			dep := r.makeDeployment(instance, r.Scheme)
+			affinity := dep.Spec.Template.Spec.Affinity
+			if !tt.wantSpread && !tt.wantNodeAffinity {
+				if affinity != nil {
+					t.Errorf("affinity = %v, want none", affinity)
+				}
+				return
+			}
+			if affinity == nil {
+				t.Fatal("affinity not set")
+			}
+			if got := affinity.NodeAffinity != nil; got != tt.wantNodeAffinity {
+				t.Errorf("node affinity set = %v, want %v", got, tt.wantNodeAffinity)
+			}
+			if !tt.wantSpread {
+				if affinity.PodAntiAffinity != nil {
+					t.Errorf("default anti-affinity added to the user affinity: %v", affinity.PodAntiAffinity)
+				}
+				return
+			}
+
+			terms := affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+			if len(terms) != 1 || terms[0].PodAffinityTerm.TopologyKey != corev1.LabelHostname {
+				t.Fatalf("anti-affinity terms = %v, want one preferred hostname term", terms)
+			}
+			selector, err := metav1.LabelSelectorAsSelector(terms[0].PodAffinityTerm.LabelSelector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !selector.Matches(labels.Set(dep.Spec.Template.Labels)) {
+				t.Errorf("anti-affinity selector %v does not match the controller pods %v", selector, dep.Spec.Template.Labels)
+			}
+		})
+	}
+}
@@ -3,9 +3,14 @@ package controller
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/cisco-open/k8s-objectmatcher/patch"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -32,9 +37,23 @@ func CreateOrUpdate(ctx context.Context, c client.Client, obj client.Object) err
 			return err
 		}
 		logger.Info("Creating a new object", "Kind", kinds, "Namespace", namespace, "Name", name)
+		markDrift(ctx)
 		return c.Create(ctx, obj)
 	} else if err == nil {
 		switch obj.(type) {
+		case *appsv1.Deployment:
+			currentDep := current.(*appsv1.Deployment)
+			dep := obj.(*appsv1.Deployment)
+			// Never strip the Istio injection annotation from the pod template,
+			// removing it would silently re-enable sidecar injection.
+			if value, ok := currentDep.Spec.Template.Annotations[istioInjectAnnotation]; ok {
+				if _, present := dep.Spec.Template.Annotations[istioInjectAnnotation]; !present {
+					if dep.Spec.Template.Annotations == nil {
+						dep.Spec.Template.Annotations = map[string]string{}
+					}
+					dep.Spec.Template.Annotations[istioInjectAnnotation] = value
+				}
+			}
 		case *corev1.Service:
 			currentSvc := current.(*corev1.Service)
 			svc := obj.(*corev1.Service)
@@ -60,6 +79,7 @@ func CreateOrUpdate(ctx context.Context, c client.Client, obj client.Object) err
 			resourceVersion := current.(metav1.ObjectMetaAccessor).GetObjectMeta().GetResourceVersion()
 			obj.(metav1.ObjectMetaAccessor).GetObjectMeta().SetResourceVersion(resourceVersion)
 
+			markDrift(ctx)
 			return c.Update(ctx, obj)
 		}
 
@@ -71,6 +91,7 @@ func CreateOrUpdate(ctx context.Context, c client.Client, obj client.Object) err
 				// "current", string(result.Current),
 			)
 
+			markDrift(ctx)
 			err := patch.DefaultAnnotator.SetLastAppliedAnnotation(obj)
 			if err != nil {
 				logger.Error(err, "failed to annotate modified object", "object", obj)
@@ -87,3 +108,76 @@ func CreateOrUpdate(ctx context.Context, c client.Client, obj client.Object) err
 	}
 	return err
 }
+
+// DeleteIfExists deletes the object and treats an already missing object as
+// success, so cleanup of disabled components can run on every reconcile.
+// Only deleting an object that still existed counts as drift.
+func DeleteIfExists(ctx context.Context, c client.Client, obj client.Object) error {
+	if err := c.Delete(ctx, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	markDrift(ctx)
+	return nil
+}
+
+type driftTrackerKey struct{}
+
+// driftTracker records whether CreateOrUpdate had to create or update an
+// object, i.e. whether it was missing or differed from the desired state, or
+// DeleteIfExists had to delete one.
+type driftTracker struct {
+	mu      sync.Mutex
+	drifted bool
+}
+
+// withDriftTracker returns a context in which CreateOrUpdate and
+// DeleteIfExists report changes to the returned tracker.
+func withDriftTracker(ctx context.Context) (context.Context, *driftTracker) {
+	tracker := &driftTracker{}
+	return context.WithValue(ctx, driftTrackerKey{}, tracker), tracker
+}
+
+func markDrift(ctx context.Context) {
+	if tracker, ok := ctx.Value(driftTrackerKey{}).(*driftTracker); ok {
+		tracker.mu.Lock()
+		tracker.drifted = true
+		tracker.mu.Unlock()
+	}
+}
+
+func (t *driftTracker) Drifted() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.drifted
+}
+
+// jitterSource draws random requeue delays. It is safe for concurrent use,
+// and a fixed seed makes the sequence reproducible.
+type jitterSource struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newJitterSource(seed int64) *jitterSource {
+	return &jitterSource{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Jitter returns d extended by a random amount of up to maxFactor times d.
+func (j *jitterSource) Jitter(d time.Duration, maxFactor float64) time.Duration {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return d + time.Duration(j.rand.Float64()*maxFactor*float64(d))
+}
+
+// Upto returns a random duration in [0, max).
+func (j *jitterSource) Upto(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return time.Duration(j.rand.Int63n(int64(max)))
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestCreateOrUpdateMarksDrift(t *testing.T) {
+	desired := func() *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "config"},
+			Data:       map[string]string{"config": "parallelism: 10"},
+		}
+	}
+	tests := []struct {
+		name        string
+		existing    func(t *testing.T, c client.Client)
+		wantDrifted bool
+	}{
+		{
+			name:        "missing object is created",
+			existing:    func(t *testing.T, c client.Client) {},
+			wantDrifted: true,
+		},
+		{
+			name: "unchanged object",
+			existing: func(t *testing.T, c client.Client) {
+				if err := CreateOrUpdate(context.Background(), c, desired()); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "object modified outside of the operator",
+			existing: func(t *testing.T, c client.Client) {
+				if err := CreateOrUpdate(context.Background(), c, desired()); err != nil {
+					t.Fatal(err)
+				}
+				current := &corev1.ConfigMap{}
+				if err := c.Get(context.Background(), client.ObjectKeyFromObject(desired()), current); err != nil {
+					t.Fatal(err)
+				}
+				current.Data["config"] = "parallelism: 1"
+				if err := c.Update(context.Background(), current); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantDrifted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClientBuilder(t).Build()
+			tt.existing(t, c)
+
+			ctx, drift := withDriftTracker(context.Background())
+			if err := CreateOrUpdate(ctx, c, desired()); err != nil {
+				t.Fatalf("CreateOrUpdate() error = %v", err)
+			}
+			if drift.Drifted() != tt.wantDrifted {
+				t.Errorf("Drifted() = %v, want %v", drift.Drifted(), tt.wantDrifted)
+			}
+			current := &corev1.ConfigMap{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(desired()), current); err != nil {
+				t.Fatal(err)
+			}
+			if current.Data["config"] != "parallelism: 10" {
+				t.Errorf("config = %q, want the desired one", current.Data["config"])
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdateKeepsIstioInjectAnnotation(t *testing.T) {
+	deployment := func(annotations map[string]string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "argo"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "controller", Image: "controller"}}},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name    string
+		live    map[string]string
+		desired map[string]string
+		want    string
+		wantSet bool
+	}{
+		{
+			name:    "annotation added outside of the operator kept",
+			live:    map[string]string{istioInjectAnnotation: "false"},
+			desired: map[string]string{configHashAnnotation: "abc"},
+			want:    "false",
+			wantSet: true,
+		},
+		{
+			name:    "kept without desired annotations",
+			live:    map[string]string{istioInjectAnnotation: "false"},
+			want:    "false",
+			wantSet: true,
+		},
+		{
+			name:    "desired value wins",
+			live:    map[string]string{istioInjectAnnotation: "false"},
+			desired: map[string]string{istioInjectAnnotation: "true"},
+			want:    "true",
+			wantSet: true,
+		},
+		{
+			name:    "not added when never set",
+			live:    map[string]string{configHashAnnotation: "abc"},
+			desired: map[string]string{configHashAnnotation: "def"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestClientBuilder(t, deployment(tt.live)).Build()
+
+			if err := CreateOrUpdate(ctx, c, deployment(tt.desired)); err != nil {
+				t.Fatalf("CreateOrUpdate() error = %v", err)
+			}
+			current := &appsv1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "argo"}, current); err != nil {
+				t.Fatal(err)
+			}
+			value, ok := current.Spec.Template.Annotations[istioInjectAnnotation]
+			if ok != tt.wantSet || value != tt.want {
+				t.Errorf("%s = %q (set %v), want %q (set %v)", istioInjectAnnotation, value, ok, tt.want, tt.wantSet)
+			}
+		})
+	}
+}
+
+func TestJitterSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		draw      func(j *jitterSource) time.Duration
+		min, max  time.Duration
+		exclusive bool
+	}{
+		{
+			name: "jitter",
+			draw: func(j *jitterSource) time.Duration { return j.Jitter(10*time.Second, 0.2) },
+			min:  10 * time.Second,
+			max:  12 * time.Second,
+		},
+		{
+			name: "jitter without factor",
+			draw: func(j *jitterSource) time.Duration { return j.Jitter(10*time.Second, 0) },
+			min:  10 * time.Second,
+			max:  10 * time.Second,
+		},
+		{
+			name:      "upto",
+			draw:      func(j *jitterSource) time.Duration { return j.Upto(time.Minute) },
+			max:       time.Minute,
+			exclusive: true,
+		},
+		{
+			name: "upto zero",
+			draw: func(j *jitterSource) time.Duration { return j.Upto(0) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := newJitterSource(42), newJitterSource(42)
+			for i := 0; i < 100; i++ {
+				got := tt.draw(a)
+				if got < tt.min || got > tt.max || (tt.exclusive && got == tt.max) {
+					t.Fatalf("draw %d = %v, want it in [%v, %v]", i, got, tt.min, tt.max)
+				}
+				if other := tt.draw(b); other != got {
+					t.Fatalf("draw %d = %v and %v with the same seed", i, got, other)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteIfExistsMarksDrift(t *testing.T) {
+	configMap := func() *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "config"}}
+	}
+	tests := []struct {
+		name        string
+		objs        []client.Object
+		wantDrifted bool
+	}{
+		{name: "existing object is deleted", objs: []client.Object{configMap()}, wantDrifted: true},
+		{name: "missing object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClientBuilder(t, tt.objs...).Build()
+
+			ctx, drift := withDriftTracker(context.Background())
+			if err := DeleteIfExists(ctx, c, configMap()); err != nil {
+				t.Fatalf("DeleteIfExists() error = %v", err)
+			}
+			if drift.Drifted() != tt.wantDrifted {
+				t.Errorf("Drifted() = %v, want %v", drift.Drifted(), tt.wantDrifted)
+			}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(configMap()), &corev1.ConfigMap{}); !errors.IsNotFound(err) {
+				t.Errorf("Get() error = %v, want NotFound", err)
+			}
+		})
+	}
+}